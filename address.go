@@ -14,6 +14,8 @@ var addrTable = addrSpace{slots: newSlotTable()}
 type boundEndpoint interface {
 	Endpoint
 	ConnectEndpoint(Endpoint)
+	admit(Endpoint) bool
+	deadLetterSink() ProtocolDeadLetterSink
 }
 type slotTable radix.Tree
 
@@ -47,11 +49,11 @@ func (a *addrSpace) Assign(addr string, ep boundEndpoint) (err error) {
 	a.Lock()
 	defer a.Unlock()
 
-	if a.slots.Occupied(addr) {
+	if prev, ok := a.slots.Get(addr); ok && !closed(prev) {
 		err = errors.New("address in use")
 	} else {
 		a.slots.Insert(addr, ep)
-		ctx.Defer(ep, a.releaseSlot(addr))
+		ctx.Defer(ep, a.releaseSlot(addr, ep))
 	}
 
 	return
@@ -62,17 +64,31 @@ func (a *addrSpace) Lookup(addr string) (ep boundEndpoint, err error) {
 	defer a.RUnlock()
 
 	var ok bool
-	if ep, ok = a.slots.Get(addr); !ok {
-		err = errors.New("unbound address")
+	if ep, ok = a.slots.Get(addr); !ok || closed(ep) {
+		ep, err = nil, errors.New("unbound address")
 	}
 
 	return
 }
 
-func (a *addrSpace) releaseSlot(addr string) func() {
+// releaseSlot frees the address, unless it was since reassigned
+func (a *addrSpace) releaseSlot(addr string, ep boundEndpoint) func() {
 	return func() {
 		a.Lock()
-		a.slots.Del(addr)
+		if cur, ok := a.slots.Get(addr); ok && cur == ep {
+			a.slots.Del(addr)
+		}
 		a.Unlock()
 	}
 }
+
+// closed returns true if the endpoint was closed, even if its slot has not
+// been released yet
+func closed(ep boundEndpoint) bool {
+	select {
+	case <-ep.Done():
+		return true
+	default:
+		return false
+	}
+}
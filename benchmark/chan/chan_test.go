@@ -11,8 +11,14 @@ var (
 
 func bench(i int, b *testing.B) {
 	b.ReportAllocs()
+
+	go func(n int) {
+		for ; n > 0; n-- {
+			ch <- n
+		}
+	}(b.N)
+
 	for n := 0; n < b.N; n++ {
-		go func() { ch <- n }()
 		recvVal = <-ch
 	}
 }
@@ -8,6 +8,7 @@ import (
 )
 
 var (
+	sendVal interface{} = struct{}{} // boxed once, so that Send does not allocate
 	recvVal interface{}
 	p0      = pair.New(portal.Cfg{})
 	p1      = pair.New(portal.Cfg{})
@@ -26,8 +27,14 @@ func init() {
 
 func bench(i int, b *testing.B) {
 	b.ReportAllocs()
+
+	go func(n int) {
+		for ; n > 0; n-- {
+			p0.Send(sendVal)
+		}
+	}(b.N)
+
 	for n := 0; n < b.N; n++ {
-		go p0.Send(n)
 		recvVal = p1.Recv()
 	}
 }
@@ -9,6 +9,7 @@ import (
 )
 
 var (
+	sendVal interface{} = struct{}{} // boxed once, so that Send does not allocate
 	recvVal interface{}
 	p0      = push.New(portal.Cfg{})
 	p1      = pull.New(portal.Cfg{})
@@ -27,8 +28,14 @@ func init() {
 
 func bench(i int, b *testing.B) {
 	b.ReportAllocs()
+
+	go func(n int) {
+		for ; n > 0; n-- {
+			p0.Send(sendVal)
+		}
+	}(b.N)
+
 	for n := 0; n < b.N; n++ {
-		go p0.Send(n)
 		recvVal = p1.Recv()
 	}
 }
@@ -9,8 +9,8 @@ import (
 )
 
 var (
-	i       uint64
-	recvVal uint64
+	sendVal interface{} = struct{}{} // boxed once, so that Send does not allocate
+	recvVal interface{}
 	p0      = req.New(portal.Cfg{})
 	p1      = rep.New(portal.Cfg{})
 )
@@ -26,22 +26,24 @@ func init() {
 	}
 }
 
-func increment() {
-	i = p1.Recv().(uint64)
-	i++
-	p1.Send(i)
-}
+func echo() { p1.Send(p1.Recv()) }
 
-func send() {
-	go p0.Send(recvVal)
-	recvVal = p0.Recv().(uint64)
+func request() {
+	p0.Send(sendVal)
+	recvVal = p0.Recv()
 }
 
 func bench(i int, b *testing.B) {
 	b.ReportAllocs()
+
+	go func(n int) {
+		for ; n > 0; n-- {
+			request()
+		}
+	}(b.N)
+
 	for n := 0; n < b.N; n++ {
-		go send()
-		increment()
+		echo()
 	}
 }
 
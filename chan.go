@@ -0,0 +1,113 @@
+package portal
+
+import "github.com/pkg/errors"
+
+// RecvChan returns a channel that yields the values received by the portal.
+// The channel is closed when the portal is closed.  A value that was received
+// but not yet read from the channel when the portal is closed is requeued if
+// the protocol supports it (see ProtocolRequeuer), and dropped otherwise.
+func RecvChan(r ReadOnly) <-chan interface{} {
+	p := coreOf(r)
+	if !p.ready.Load() {
+		panic(errors.New("recv from disconnected portal"))
+	}
+
+	ch := make(chan interface{})
+	go p.recvInto(ch)
+	return ch
+}
+
+// SendChan returns a channel whose values are sent through the portal.
+// Closing the channel closes the portal.  Values written to the channel after
+// the portal was closed are discarded.
+func SendChan(w WriteOnly) chan<- interface{} {
+	p := coreOf(w)
+	if !p.ready.Load() {
+		panic(errors.New("send to disconnected portal"))
+	}
+
+	ch := make(chan interface{})
+	go func() {
+		if !p.sendFrom(ch) {
+			for range ch {
+				// keep writers from blocking until the channel is closed
+			}
+		}
+	}()
+
+	return ch
+}
+
+// FromChan binds the portal to the address and connects it to an existing
+// channel.  The direction of the channel determines the direction of the flow.
+//
+// If ch is a <-chan interface{}, the values it yields are sent through the
+// portal, which should be write-capable (e.g. PUSH).  The portal is closed once
+// ch is closed.
+//
+// If ch is a chan<- interface{}, the values received by the portal, which should
+// be read-capable (e.g. PULL), are written to ch.  ch is closed once the portal
+// is closed.
+func FromChan(t Transporter, addr string, ch interface{}) error {
+	p := coreOf(t)
+
+	switch c := ch.(type) {
+	case <-chan interface{}:
+		if err := p.Bind(addr); err != nil {
+			return err
+		}
+
+		go p.sendFrom(c)
+	case chan<- interface{}:
+		if err := p.Bind(addr); err != nil {
+			return err
+		}
+
+		go p.recvInto(c)
+	default:
+		return errors.Errorf("expected <-chan interface{} or chan<- interface{}, got %T", ch)
+	}
+
+	return nil
+}
+
+// recvInto writes received values to ch until the portal is closed, then
+// closes ch.
+func (p *portal) recvInto(ch chan<- interface{}) {
+	defer close(ch)
+
+	for msg := p.RecvMsg(); msg != nil; msg = p.RecvMsg() {
+		v, ok := p.decodeMsg(msg)
+		if !ok {
+			continue
+		}
+
+		select {
+		case ch <- v:
+			p.consume(msg)
+		case <-p.Done():
+			if !p.handOff(msg) {
+				p.Drop(msg, ErrClosed)
+			}
+			return
+		}
+	}
+}
+
+// sendFrom sends the values yielded by ch until ch is closed, then closes the
+// portal.  It returns false if the portal was closed first.
+func (p *portal) sendFrom(ch <-chan interface{}) bool {
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				p.Close()
+				return true
+			}
+
+			p.transmit(v, 0, nil, nil)
+		case <-p.Done():
+			return false
+		}
+	}
+}
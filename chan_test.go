@@ -0,0 +1,160 @@
+package portal
+
+import (
+	"testing"
+	"time"
+)
+
+// requeueProto takes back the messages requeued by the portal
+type requeueProto struct {
+	mockProtoExt
+	q chan *Message
+}
+
+func (p requeueProto) Requeue(msg *Message) bool {
+	p.q <- msg
+	return true
+}
+
+func TestChan(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	deliver := func(ptl *portal, v interface{}) {
+		msg := NewMsg()
+		msg.Value = v
+		msg.detach()
+		ptl.chRecv <- msg
+	}
+
+	t.Run("RecvChan", func(t *testing.T) {
+		ptl, _ := mkSendRecvTestPortal(p, 1)
+		ptl.setRunning()
+
+		ch := RecvChan(ptl)
+		deliver(ptl, "hello")
+
+		select {
+		case v := <-ch:
+			if v != "hello" {
+				t.Errorf("unexpected value %v", v)
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("value not received")
+		}
+
+		ptl.Close()
+		select {
+		case v, ok := <-ch:
+			if ok {
+				t.Errorf("unexpected value %v", v)
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Error("channel not closed")
+		}
+	})
+
+	t.Run("RecvChanClose", func(t *testing.T) {
+		rp := requeueProto{mockProtoExt: p, q: make(chan *Message, 1)}
+		ptl, _ := mkSendRecvTestPortal(rp, 1)
+		ptl.setRunning()
+
+		RecvChan(ptl)
+		deliver(ptl, "hello")
+
+		// wait for the value to be taken from the portal
+		for len(ptl.chRecv) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		ptl.Close()
+
+		select {
+		case msg := <-rp.q:
+			if msg.Value != "hello" {
+				t.Errorf("unexpected value %v", msg.Value)
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("unread value not requeued")
+		}
+
+		if n := ptl.Stats().Dropped; n != 0 {
+			t.Errorf("expected no drops, got %d", n)
+		}
+	})
+
+	t.Run("SendChan", func(t *testing.T) {
+		ptl, _ := mkSendRecvTestPortal(p, 1)
+		ptl.setRunning()
+
+		ch := SendChan(ptl)
+		ch <- "hello"
+
+		msg := <-ptl.chSend
+		if msg.Value != "hello" {
+			t.Errorf("unexpected value %v", msg.Value)
+		}
+		msg.Free()
+
+		close(ch)
+		select {
+		case <-ptl.Done():
+		case <-time.After(time.Millisecond * 100):
+			t.Error("portal not closed")
+		}
+	})
+
+	t.Run("FromChan", func(t *testing.T) {
+		t.Run("Push", func(t *testing.T) {
+			ptl, _ := mkSendRecvTestPortal(p, 1)
+
+			ch := make(chan interface{})
+			if err := FromChan(ptl, "/test/chan/push", (<-chan interface{})(ch)); err != nil {
+				t.Fatal(err)
+			}
+
+			ch <- "hello"
+			msg := <-ptl.chSend
+			if msg.Value != "hello" {
+				t.Errorf("unexpected value %v", msg.Value)
+			}
+			msg.Free()
+
+			close(ch)
+			select {
+			case <-ptl.Done():
+			case <-time.After(time.Millisecond * 100):
+				t.Error("portal not closed")
+			}
+		})
+
+		t.Run("Pull", func(t *testing.T) {
+			ptl, _ := mkSendRecvTestPortal(p, 1)
+
+			ch := make(chan interface{})
+			if err := FromChan(ptl, "/test/chan/pull", (chan<- interface{})(ch)); err != nil {
+				t.Fatal(err)
+			}
+
+			deliver(ptl, "hello")
+			if v := <-ch; v != "hello" {
+				t.Errorf("unexpected value %v", v)
+			}
+
+			ptl.Close()
+			if _, ok := <-ch; ok {
+				t.Error("channel not closed")
+			}
+		})
+
+		t.Run("Bidirectional", func(t *testing.T) {
+			ptl, _ := mkSendRecvTestPortal(p, 1)
+			defer ptl.Close()
+
+			if err := FromChan(ptl, "/test/chan/bidi", make(chan interface{})); err == nil {
+				t.Error("expected error for bidirectional channel")
+			}
+		})
+	})
+}
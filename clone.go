@@ -0,0 +1,110 @@
+package portal
+
+import "reflect"
+
+// Cloner is implemented by values that know how to deep-copy themselves.  It
+// is used by portals that have Cfg.CopyOnSend set.
+type Cloner interface {
+	Clone() interface{}
+}
+
+// clone returns a deep copy of v.  Values implementing Cloner are copied by
+// calling Clone.  Other values are copied by reflection; note that unexported
+// struct fields, channels and functions are copied shallowly.
+func clone(v interface{}) interface{} {
+	if c, ok := v.(Cloner); ok {
+		return c.Clone()
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	cp := copier{seen: make(map[ptrKey]reflect.Value)}
+	return cp.copy(reflect.ValueOf(v)).Interface()
+}
+
+type ptrKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+// Clone returns a deep copy of v, as made for each recipient by portals that
+// have Cfg.CopyOnSend set.  It is intended for protocols that deliver a value
+// more than once, e.g. to replay it.
+func Clone(v interface{}) interface{} { return clone(v) }
+
+type copier struct {
+	seen map[ptrKey]reflect.Value // guards against cyclic pointers
+}
+
+func (c copier) copy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+
+		key := ptrKey{t: v.Type(), p: v.Pointer()}
+		if cp, ok := c.seen[key]; ok {
+			return cp
+		}
+
+		cp := reflect.New(v.Type().Elem())
+		c.seen[key] = cp
+		cp.Elem().Set(c.copy(v.Elem()))
+		return cp
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(c.copy(v.Elem()))
+		return cp
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return cp
+
+	case reflect.Array:
+		cp := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return cp
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			cp.SetMapIndex(c.copy(iter.Key()), c.copy(iter.Value()))
+		}
+		return cp
+
+	case reflect.Struct:
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v) // shallow copy, including unexported fields
+
+		for i := 0; i < cp.NumField(); i++ {
+			if f := cp.Field(i); f.CanSet() {
+				f.Set(c.copy(v.Field(i)))
+			}
+		}
+		return cp
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,184 @@
+package portal
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+)
+
+type node struct {
+	Name     string
+	Tags     []string
+	Attrs    map[string]int
+	Next     *node
+	Any      interface{}
+	internal *int
+}
+
+type clonerVal struct{ called *bool }
+
+func (c clonerVal) Clone() interface{} {
+	*c.called = true
+	return c
+}
+
+func TestClone(t *testing.T) {
+	t.Run("Deep", func(t *testing.T) {
+		n := &node{
+			Name:  "a",
+			Tags:  []string{"x"},
+			Attrs: map[string]int{"k": 1},
+			Next:  &node{Name: "b"},
+			Any:   []int{1},
+		}
+
+		cp := clone(n).(*node)
+		if cp == n || cp.Next == n.Next {
+			t.Fatal("pointers were not copied")
+		}
+
+		cp.Tags[0] = "y"
+		cp.Attrs["k"] = 2
+		cp.Next.Name = "c"
+		cp.Any.([]int)[0] = 2
+
+		if n.Tags[0] != "x" || n.Attrs["k"] != 1 || n.Next.Name != "b" || n.Any.([]int)[0] != 1 {
+			t.Errorf("modifying the copy modified the original: %+v", n)
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		n := &node{Name: "loop"}
+		n.Next = n
+
+		cp := clone(n).(*node)
+		if cp.Next != cp {
+			t.Error("cycle was not preserved")
+		}
+	})
+
+	t.Run("Unexported", func(t *testing.T) {
+		i := 1
+		n := node{internal: &i}
+
+		if cp := clone(n).(node); cp.internal != n.internal {
+			t.Error("unexported fields should be copied shallowly")
+		}
+	})
+
+	t.Run("Cloner", func(t *testing.T) {
+		var called bool
+		clone(clonerVal{called: &called})
+
+		if !called {
+			t.Error("Clone method was not used")
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		if clone(nil) != nil {
+			t.Error("expected nil")
+		}
+	})
+}
+
+func TestShare(t *testing.T) {
+	t.Run("Ref", func(t *testing.T) {
+		m := NewMsg()
+		m.Value = []int{1}
+
+		if cp := m.Share(); cp != m {
+			t.Error("Share should return the message itself when copy-on-send is disabled")
+		}
+
+		m.Free()
+		m.Free()
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		m := NewMsg()
+		m.Value = []int{1}
+		m.clone = clone
+
+		cp := m.Share()
+		m.Free() // release the sender's reference
+
+		if cp == m {
+			t.Fatal("expected a new message")
+		}
+
+		cp.Value.([]int)[0] = 2
+		if m.Value.([]int)[0] != 1 {
+			t.Error("recipient modified the original value")
+		}
+
+		ch := make(chan struct{})
+		go func() {
+			m.wait()
+			close(ch)
+		}()
+
+		select {
+		case <-ch:
+			t.Fatal("original released before copy was freed")
+		case <-time.After(time.Millisecond):
+		}
+
+		cp.Free()
+
+		select {
+		case <-ch:
+		case <-time.After(time.Millisecond * 100):
+			t.Error("freeing the copy did not release the original")
+		}
+	})
+}
+
+func TestDetectMutation(t *testing.T) {
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	ptl := newPortal(mockProto{}, Cfg{Doner: d, Size: 8, DetectMutation: true}, cancel)
+	defer ptl.Close()
+
+	ptl.setRunning()
+
+	v := &node{Name: "original"}
+	ptl.Send(v)
+	ptl.Send(&node{})
+
+	v.Name = "modified"
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("mutation was not detected")
+		}
+	}()
+
+	ptl.Send(&node{})
+}
+
+func TestUnchanged(t *testing.T) {
+	type hidden struct {
+		m map[string]float64
+		f func()
+	}
+
+	for _, v := range []interface{}{
+		math.NaN(),
+		[]float64{1, math.NaN()},
+		&hidden{m: map[string]float64{"nan": math.NaN()}, f: func() {}},
+	} {
+		if !unchanged(v, clone(v)) {
+			t.Errorf("copy of %#v reported as modified", v)
+		}
+	}
+
+	v := &node{Name: "original"}
+	cp := clone(v)
+	v.Name = "modified"
+
+	if unchanged(v, cp) {
+		t.Error("modification not detected")
+	}
+}
@@ -0,0 +1,120 @@
+// Package codec provides portal.Codec implementations for common
+// serialization formats.
+//
+// Each codec accepts an optional New function that allocates the value into
+// which data are decoded.  When New is set, Decode returns the value allocated
+// by New (typically a pointer).  When New is nil, values are decoded into an
+// empty interface, with format-specific results (e.g. JSON objects are decoded
+// into map[string]interface{}).
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/lthibault/portal"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ portal.Codec = Gob{}
+	_ portal.Codec = JSON{}
+	_ portal.Codec = Msgpack{}
+	_ portal.Codec = Protobuf{}
+)
+
+// Gob encodes values using encoding/gob.  If New is nil, the concrete types of
+// encoded values must be registered with gob.Register.
+type Gob struct{ New func() interface{} }
+
+// Encode implements portal.Codec
+func (c Gob) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	var err error
+	if c.New == nil {
+		err = enc.Encode(&v) // encode as interface to transmit type information
+	} else {
+		err = enc.Encode(v)
+	}
+
+	return buf.Bytes(), err
+}
+
+// Decode implements portal.Codec
+func (c Gob) Decode(b []byte) (v interface{}, err error) {
+	dec := gob.NewDecoder(bytes.NewReader(b))
+
+	if c.New == nil {
+		err = dec.Decode(&v)
+	} else {
+		v = c.New()
+		err = dec.Decode(v)
+	}
+
+	return
+}
+
+// JSON encodes values using encoding/json
+type JSON struct{ New func() interface{} }
+
+// Encode implements portal.Codec
+func (c JSON) Encode(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Decode implements portal.Codec
+func (c JSON) Decode(b []byte) (v interface{}, err error) {
+	if c.New == nil {
+		err = json.Unmarshal(b, &v)
+	} else {
+		v = c.New()
+		err = json.Unmarshal(b, v)
+	}
+
+	return
+}
+
+// Msgpack encodes values using MessagePack
+type Msgpack struct{ New func() interface{} }
+
+// Encode implements portal.Codec
+func (c Msgpack) Encode(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+// Decode implements portal.Codec
+func (c Msgpack) Decode(b []byte) (v interface{}, err error) {
+	if c.New == nil {
+		err = msgpack.Unmarshal(b, &v)
+	} else {
+		v = c.New()
+		err = msgpack.Unmarshal(b, v)
+	}
+
+	return
+}
+
+// Protobuf encodes protocol buffer messages.  New is required, since protocol
+// buffers are not self-describing.
+type Protobuf struct{ New func() proto.Message }
+
+// Encode implements portal.Codec
+func (c Protobuf) Encode(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T does not implement proto.Message", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+// Decode implements portal.Codec
+func (c Protobuf) Decode(b []byte) (interface{}, error) {
+	if c.New == nil {
+		return nil, errors.New("protobuf codec requires New")
+	}
+
+	m := c.New()
+	return m, proto.Unmarshal(b, m)
+}
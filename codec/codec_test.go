@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"encoding/gob"
+	"testing"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type point struct{ X, Y int }
+
+func init() { gob.Register(point{}) }
+
+func roundTrip(t *testing.T, c portal.Codec, v interface{}) interface{} {
+	b, err := c.Encode(v)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	out, err := c.Decode(b)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	return out
+}
+
+func TestCodecs(t *testing.T) {
+	newPoint := func() interface{} { return new(point) }
+
+	t.Run("Gob", func(t *testing.T) {
+		if v := roundTrip(t, Gob{}, point{1, 2}); v.(point) != (point{1, 2}) {
+			t.Errorf("unexpected value %v", v)
+		}
+
+		if v := roundTrip(t, Gob{New: newPoint}, point{1, 2}); *v.(*point) != (point{1, 2}) {
+			t.Errorf("unexpected value %v", v)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		if v := roundTrip(t, JSON{}, "hello"); v.(string) != "hello" {
+			t.Errorf("unexpected value %v", v)
+		}
+
+		if v := roundTrip(t, JSON{New: newPoint}, point{1, 2}); *v.(*point) != (point{1, 2}) {
+			t.Errorf("unexpected value %v", v)
+		}
+	})
+
+	t.Run("Msgpack", func(t *testing.T) {
+		if v := roundTrip(t, Msgpack{}, "hello"); v.(string) != "hello" {
+			t.Errorf("unexpected value %v", v)
+		}
+
+		if v := roundTrip(t, Msgpack{New: newPoint}, point{1, 2}); *v.(*point) != (point{1, 2}) {
+			t.Errorf("unexpected value %v", v)
+		}
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		c := Protobuf{New: func() proto.Message { return new(wrapperspb.StringValue) }}
+		if v := roundTrip(t, c, wrapperspb.String("hello")); v.(*wrapperspb.StringValue).GetValue() != "hello" {
+			t.Errorf("unexpected value %v", v)
+		}
+
+		if _, err := c.Encode("hello"); err == nil {
+			t.Error("encoding a non-proto.Message should fail")
+		}
+
+		if _, err := (Protobuf{}).Decode(nil); err == nil {
+			t.Error("decoding without New should fail")
+		}
+	})
+}
+
+func mkPipeline(t *testing.T, addr string, pushCfg, pullCfg portal.Cfg) (portal.WriteOnly, portal.ReadOnly) {
+	pushP := push.New(pushCfg)
+	pullP := pull.New(pullCfg)
+
+	if err := pushP.Bind(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pullP.Connect(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	return pushP, pullP
+}
+
+func TestByteMode(t *testing.T) {
+	c := JSON{New: func() interface{} { return new(point) }}
+
+	t.Run("Encode", func(t *testing.T) {
+		pushP, pullP := mkPipeline(t, "/test/codec/encode", portal.Cfg{Codec: c}, portal.Cfg{})
+		defer pushP.Close()
+		defer pullP.Close()
+
+		go pushP.Send(point{1, 2})
+
+		if b, ok := pullP.Recv().([]byte); !ok || string(b) != `{"X":1,"Y":2}` {
+			t.Errorf("expected encoded value, got %v", b)
+		}
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		pushP, pullP := mkPipeline(t, "/test/codec/decode", portal.Cfg{Codec: c}, portal.Cfg{Codec: c})
+		defer pushP.Close()
+		defer pullP.Close()
+
+		go pushP.Send(point{3, 4})
+
+		if v := pullP.Recv(); *v.(*point) != (point{3, 4}) {
+			t.Errorf("expected decoded value, got %v", v)
+		}
+	})
+}
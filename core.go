@@ -1,15 +1,120 @@
 package portal
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/SentimensRG/ctx"
 	"github.com/SentimensRG/ctx/sigctx"
 	"github.com/pkg/errors"
 )
 
+var (
+	// ErrExpired is the reason given when a message is dropped because its
+	// deadline has passed.
+	ErrExpired = errors.New("message expired")
+
+	// ErrClosed is the reason given when a message is dropped because the
+	// portal or its peer was closed.
+	ErrClosed = errors.New("portal closed")
+
+	// ErrFiltered is the reason given when a message is dropped by a protocol
+	// hook or middleware.
+	ErrFiltered = errors.New("message filtered")
+)
+
 // Cfg is a base configuration struct
 type Cfg struct {
 	ctx.Doner
 	Size int
+
+	// Middleware is applied to every message sent or received by the portal
+	Middleware []Middleware
+
+	// SendLimit throttles calls to Send
+	SendLimit Limit
+
+	// PeerLimit throttles delivery to each peer.  It is honored by fan-out
+	// protocols (e.g. PUB and BUS).
+	PeerLimit Limit
+
+	// PriorityQueue causes messages with a higher Priority to overtake those
+	// with a lower Priority, both when sending and when receiving.
+	PriorityQueue bool
+
+	// TTL sets a deadline on every message sent through the portal.  Messages
+	// that are still undelivered when their deadline passes are dropped.  A
+	// zero TTL means messages never expire.
+	TTL time.Duration
+
+	// Codec, if set, places the portal in byte mode:  values are encoded to
+	// []byte by Send, and decoded by Recv.
+	Codec Codec
+
+	// CopyOnSend causes fan-out protocols (e.g. PUB, BUS and STAR) to deliver
+	// a deep copy of each value to every recipient, rather than sharing it.
+	// Values implementing Cloner are copied by calling Clone.  Portals in
+	// byte mode do not need this, since each recipient decodes its own value.
+	CopyOnSend bool
+
+	// DetectMutation is a debugging aid.  The portal keeps a deep copy of
+	// each value it sends, and Send panics if it finds that a recently-sent
+	// value was modified.  Only the last few values are checked, and only
+	// when the next value is sent, so a change that is undone in the meantime
+	// goes unnoticed.  Maps keyed by pointers always appear modified, since
+	// their copies have different keys.  Copying makes sending much slower.
+	DetectMutation bool
+
+	// SendLog, if set, persists each value sent through the portal until it
+	// has been delivered.  Values that were not delivered before the portal
+	// shut down are sent again when a portal using the same log is bound or
+	// connected.  Values that cannot be logged are dropped, and errors
+	// reading the log are reported to OnError.
+	SendLog Log
+
+	// RecvLog, if set, persists each value received by the portal until it is
+	// returned by Recv.  Values that were not consumed before the portal shut
+	// down are returned first by the next portal to use the same log.  Once a
+	// value is persisted, it counts as delivered for the sender.  Errors
+	// reading the log are reported to OnError.
+	RecvLog Log
+
+	// AckTimeout is the visibility timeout for values received in manual-ack
+	// mode (see RecvDelivery).  Values that are not acknowledged in time are
+	// redelivered.  It defaults to DefaultAckTimeout.
+	AckTimeout time.Duration
+
+	// DeadLetter is the address of a portal to which dropped messages are
+	// routed, e.g. a PULL portal bound by an operator's tool.  The portal's
+	// protocol must implement ProtocolDeadLetterSink.  Dead letters are
+	// annotated with HeaderDropReason and HeaderAddress.  Delivery never
+	// blocks:  dead letters are discarded if the dead-letter portal is full,
+	// and counted in Stats.DeadLetterOverflow.
+	DeadLetter string
+
+	// Sequence stamps each message sent through the portal with a monotonic
+	// sequence number (see HeaderSeq), and causes Recv to return Sequenced
+	// values for messages that carry one.  It is used with a reordering PULL
+	// portal to restore the order of values processed by parallel workers.
+	Sequence bool
+
+	// OnError is called with errors that occur in the background, such as a
+	// *PanicError recovered from a protocol goroutine.  By default, errors are
+	// logged.
+	OnError func(error)
+
+	// OnPeer is called when a peer is added to or removed from the portal.
+	// If it returns false for a PeerAdding event, the peer is rejected and
+	// Connect returns ErrRejected;  the return value is ignored for other
+	// events.  OnPeer is called synchronously, and must not block.
+	OnPeer func(PeerEvent) bool
+}
+
+// Codec serializes values sent through a portal
+type Codec interface {
+	Encode(interface{}) ([]byte, error)
+	Decode([]byte) (interface{}, error)
 }
 
 // Async returns true if the Portal is buffered
@@ -18,8 +123,8 @@ func (c Cfg) Async() bool { return c.Size > 0 }
 // MakePortal is for protocol implementations
 func MakePortal(cfg Cfg, p Protocol) Portal {
 	var cancel func()
-	var d ctx.Doner
-	if cfg.Doner == nil {
+	var d = cfg.Doner
+	if d == nil {
 		d = sigctx.New()
 	}
 
@@ -32,11 +137,28 @@ type portal struct {
 	cancel func()
 
 	id    ID
+	addr  string // most recently bound or connected address
 	proto Protocol
-	ready bool
+	ready atomic.Bool
+
+	replay sync.Once // replays the send log
+
+	chSend chan *Message // written by the portal
+	chRecv chan *Message // read by the portal
+
+	sendQ          <-chan *Message // read by the protocol
+	recvQ          chan<- *Message // written by the protocol
+	sendPQ, recvPQ *prioQueue
+	redeliverQ     chan<- *Message // bypasses the receive log
 
-	chSend chan *Message
-	chRecv chan *Message
+	nextSeq uint64 // last sequence number assigned (see Cfg.Sequence)
+
+	stats     *counters
+	limit     *Limiter
+	mutations *mutationDetector
+
+	connLock sync.Mutex
+	conns    map[ID]conn
 
 	ProtocolSendHook
 	ProtocolRecvHook
@@ -49,32 +171,71 @@ func newPortal(p Protocol, cfg Cfg, cancel func()) *portal {
 	ptl.cancel = cancel
 	ptl.id = NewID()
 	ptl.proto = p
-	ptl.chSend = make(chan *Message, cfg.Size)
-	ptl.chRecv = make(chan *Message, cfg.Size)
 
-	if i, ok := interface{}(p).(ProtocolSendHook); ok {
-		ptl.ProtocolSendHook = i.(ProtocolSendHook)
+	if cfg.PriorityQueue {
+		ptl.sendPQ = newPrioQueue(cfg.Doner, cfg.Size, ptl.Drop)
+		ptl.recvPQ = newPrioQueue(cfg.Doner, cfg.Size, ptl.Drop)
+		ptl.chSend, ptl.sendQ = ptl.sendPQ.in, ptl.sendPQ.out
+		ptl.chRecv, ptl.recvQ = ptl.recvPQ.out, ptl.recvPQ.in
+	} else {
+		ptl.chSend = make(chan *Message, cfg.Size)
+		ptl.chRecv = make(chan *Message, cfg.Size)
+		ptl.sendQ, ptl.recvQ = ptl.chSend, ptl.chRecv
+	}
+
+	ptl.redeliverQ = ptl.recvQ
+	if cfg.RecvLog != nil {
+		in := make(chan *Message, cfg.Size)
+		go ptl.logRecv(in, ptl.recvQ)
+		ptl.recvQ = in
 	}
-	if i, ok := interface{}(p).(ProtocolRecvHook); ok {
-		ptl.ProtocolRecvHook = i.(ProtocolRecvHook)
+
+	ptl.conns = make(map[ID]conn)
+	ptl.stats = newCounters()
+	ptl.limit = NewLimiter(cfg.SendLimit)
+	if cfg.DetectMutation {
+		ptl.mutations = newMutationDetector()
 	}
 
+	ptl.ProtocolSendHook = mkSendChain(p, cfg.Middleware)
+	ptl.ProtocolRecvHook = mkRecvChain(p, cfg.Middleware)
+
 	p.Init(ptl)
 
+	if q, ok := p.(ProtocolSendQueue); ok {
+		if ch := q.SendQueue(); ch != nil {
+			ptl.sendQ = ch
+		}
+	}
+
+	if q, ok := p.(ProtocolRecvQueue); ok {
+		if ch := q.RecvQueue(); ch != nil {
+			ptl.recvQ = ch
+		}
+	}
+
 	return ptl
 }
 
 func (p *portal) setRunning() {
-	p.ready = true
-	ctx.Defer(p, func() { p.ready = false })
+	p.ready.Store(true)
+	ctx.Defer(p, func() { p.ready.Store(false) })
+
+	if p.SendLog != nil {
+		p.replay.Do(func() { go p.replaySendLog() })
+	}
 }
 
 func (p *portal) Connect(addr string) (err error) {
-	if boundEP, err := addrTable.Lookup(addr); err != nil {
+	var boundEP boundEndpoint
+	if boundEP, err = addrTable.Lookup(addr); err != nil {
 		err = errors.Wrap(err, addr)
+	} else if !boundEP.admit(p) || !p.admit(boundEP) {
+		err = errors.Wrap(ErrRejected, addr)
 	} else {
 		boundEP.ConnectEndpoint(p)
 		p.ConnectEndpoint(boundEP)
+		p.addr = addr
 		p.setRunning()
 	}
 
@@ -82,75 +243,391 @@ func (p *portal) Connect(addr string) (err error) {
 }
 
 func (p *portal) Bind(addr string) (err error) {
-	if err := addrTable.Assign(addr, p); err != nil {
+	if err = addrTable.Assign(addr, p); err != nil {
 		err = errors.Wrap(err, addr)
 	} else {
+		p.addr = addr
 		p.setRunning()
 	}
 
 	return
 }
 
-func (p *portal) Send(v interface{}) {
-	if !p.ready {
+func (p *portal) Send(v interface{}) { p.SendPriority(v, 0) }
+
+// SendPriority sends a value with the specified priority.  Priorities are only
+// meaningful if Cfg.PriorityQueue is set.
+func (p *portal) SendPriority(v interface{}, prio int) { p.send(v, prio, nil, nil) }
+
+// SendHeader sends a value along with a header, e.g. to propagate metadata
+// that is not part of the value itself.  The header is copied.
+func (p *portal) SendHeader(v interface{}, h Header) { p.send(v, 0, h, nil) }
+
+// SendAsync sends a value without waiting for it to be delivered.  The returned
+// Future resolves once all recipients have received the value.
+func (p *portal) SendAsync(v interface{}) Future {
+	f := newFuture()
+	p.send(v, 0, nil, f)
+	return f
+}
+
+func (p *portal) send(v interface{}, prio int, h Header, f *future) {
+	if !p.ready.Load() {
 		panic(errors.New("send to disconnected portal"))
 	}
 
-	msg := NewMsg()
-	msg.Value = v
+	start := time.Now()
+	defer func() { p.stats.sendLatency.Observe(time.Since(start)) }()
+
+	p.transmit(v, prio, h, f)
+}
+
+// transmit a value without checking that the portal is running
+func (p *portal) transmit(v interface{}, prio int, h Header, f *future) {
+	msg, f, wait := p.outgoing(v, prio, h, f)
+	if msg == nil {
+		return // portal closed while throttled
+	}
 
 	p.SendMsg(msg)
+	if wait {
+		p.await(msg, f)
+	}
+}
+
+// outgoing builds the message for a value that is about to be sent.  It returns
+// nil if the portal was closed while the sender was throttled, or if the value
+// could not be recorded in the send log, in which case it is dropped.  If wait
+// is true, the sender must call await once the message has been sent (or
+// freed).
+func (p *portal) outgoing(v interface{}, prio int, h Header, f *future) (msg *Message, _ *future, wait bool) {
+	if p.mutations != nil {
+		if err := p.mutations.Check(); err != nil {
+			panic(err)
+		}
+	}
+
+	if !p.limit.Wait(p) {
+		if f != nil {
+			f.dropped(ErrClosed)
+			f.resolve()
+		}
+		return
+	}
 
-	if p.Async() {
-		go msg.wait()
+	wait = f == nil && !p.Async()
+
+	var logErr error
+	if p.SendLog != nil {
+		if f == nil {
+			f = newFuture()
+		}
+
+		if f.seq == 0 { // not replayed
+			f.seq, logErr = p.SendLog.Append(v)
+		}
+
+		if logErr == nil {
+			f.log = p.SendLog
+		}
+	}
+
+	var seq uint64
+	if p.Sequence {
+		v, seq = p.sequence(v)
+	}
+
+	msg = NewMsg()
+	msg.Value = v
+	msg.Priority = prio
+	for k, v := range h {
+		msg.Annotate(k, v)
+	}
+
+	if seq != 0 {
+		msg.Annotate(HeaderSeq, seq)
+	}
+
+	if p.TTL > 0 {
+		msg.Deadline = time.Now().Add(p.TTL)
+	}
+
+	switch {
+	case f != nil:
+		msg.receipt = f
+		msg.done = settle
+	case !wait:
+		msg.detach()
+	}
+
+	if logErr != nil {
+		p.Drop(msg, errors.Wrap(logErr, "send log"))
+		return nil, f, false
+	}
+
+	return msg, f, wait
+}
+
+// await the delivery of a message built by outgoing
+func (p *portal) await(msg *Message, f *future) {
+	if f != nil {
+		<-f.done
 	} else {
 		msg.wait()
 	}
 }
 
 func (p *portal) Recv() (v interface{}) {
-	if !p.ready {
+	var msg *Message
+	if msg, v = p.recv(); msg != nil {
+		p.consume(msg)
+	}
+
+	return
+}
+
+// RecvHeader is like Recv, but also returns a copy of the message header, if
+// any
+func (p *portal) RecvHeader() (v interface{}, h Header) {
+	var msg *Message
+	if msg, v = p.recv(); msg != nil {
+		h = msg.Header.clone()
+		p.consume(msg)
+	}
+
+	return
+}
+
+// RecvOK is like Recv, but ok is false if the portal was closed.  This
+// distinguishes a closed portal from a nil value.
+func (p *portal) RecvOK() (v interface{}, ok bool) {
+	if !p.running() {
+		return
+	}
+
+	var msg *Message
+	if msg, v = p.recvUntil(nil); msg != nil {
+		p.consume(msg)
+		ok = true
+	}
+
+	return
+}
+
+// running returns false if the portal was closed, and panics if it was never
+// bound or connected.
+func (p *portal) running() bool {
+	if p.ready.Load() {
+		return true
+	}
+
+	select {
+	case <-p.Done(): // ready is reset after the portal is closed
+		return false
+	default:
 		panic(errors.New("recv from disconnected portal"))
 	}
+}
 
-	if msg := p.RecvMsg(); msg != nil {
-		v = msg.Value
-		msg.Free()
+// recv returns the next message and its decoded value.  The caller takes
+// ownership of the message.
+func (p *portal) recv() (msg *Message, v interface{}) {
+	if !p.ready.Load() {
+		panic(errors.New("recv from disconnected portal"))
+	}
+
+	return p.recvUntil(nil)
+}
+
+// recvUntil is like recv, but returns a nil message if the expired channel
+// fires first.
+func (p *portal) recvUntil(expired <-chan struct{}) (msg *Message, v interface{}) {
+	start := time.Now()
+	defer func() { p.stats.recvLatency.Observe(time.Since(start)) }()
+
+	var ok bool
+	for msg = p.recvMsg(expired); msg != nil; msg = p.recvMsg(expired) {
+		if v, ok = p.decodeMsg(msg); ok {
+			break
+		}
 	}
 
 	return
 }
 
-func (p *portal) SendMsg(msg *Message) {
-	if (p.ProtocolSendHook != nil) && !p.SendHook(msg) {
+// decodeMsg decodes the value of an incoming message.  If decoding fails, the
+// message is dropped.  Values rejected by a ProtocolRecvFilter are discarded.
+func (p *portal) decodeMsg(msg *Message) (interface{}, bool) {
+	v, err := p.decode(msg.Value)
+	if err != nil {
+		p.Drop(msg, errors.Wrap(err, "decode"))
+		return nil, false
+	}
+
+	if f, ok := p.proto.(ProtocolRecvFilter); ok && !f.Filter(v) {
+		p.ack(msg)
 		msg.Free()
-		return // drop msg silently
+		return nil, false
+	}
+
+	if p.Sequence {
+		v = sequenced(msg, v)
+	}
+
+	return v, true
+}
+
+// consume releases a message that was received by the application
+func (p *portal) consume(msg *Message) {
+	msg.delivered(p.id)
+	p.ack(msg)
+	msg.Free()
+}
+
+// decode a value received in byte mode.  The message may be shared with other
+// receivers, so the decoded value is returned rather than stored in the message.
+func (p *portal) decode(v interface{}) (interface{}, error) {
+	if p.Codec == nil {
+		return v, nil
+	}
+
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errors.Errorf("expected []byte, got %T", v)
+	}
+
+	return p.Codec.Decode(b)
+}
+
+func (p *portal) SendMsg(msg *Message) {
+	if !p.prepare(msg) {
+		return
 	}
 
+	v := msg.Value // msg may be recycled as soon as it is sent
+	p.track(v)
+
 	select {
 	case p.chSend <- msg:
+		p.stats.Sent(v)
 	case <-p.Done():
-		msg.Free()
+		p.Drop(msg, ErrClosed)
+	}
+}
+
+// prepare an outgoing message for the protocol.  It returns false if the
+// message could not be encoded, or was dropped by a send hook.
+func (p *portal) prepare(msg *Message) bool {
+	if p.Codec != nil {
+		b, err := p.Codec.Encode(msg.Value)
+		if err != nil {
+			p.Drop(msg, errors.Wrap(err, "encode"))
+			return false
+		}
+		msg.Value = b
+	}
+
+	if (p.ProtocolSendHook != nil) && !p.SendHook(msg) {
+		p.Drop(msg, ErrFiltered)
+		return false // drop msg silently
+	}
+
+	if p.CopyOnSend && p.Codec == nil {
+		msg.clone = clone
 	}
+
+	return true
 }
 
-func (p *portal) RecvMsg() *Message {
+// track a value that was handed to the protocol (see Cfg.DetectMutation)
+func (p *portal) track(v interface{}) {
+	if p.mutations != nil {
+		p.mutations.Track(v)
+	}
+}
+
+func (p *portal) RecvMsg() *Message { return p.recvMsg(nil) }
+
+// recvMsg is like RecvMsg, but returns nil if the expired channel fires first
+func (p *portal) recvMsg(expired <-chan struct{}) *Message {
 	for {
 		select {
 		case msg := <-p.chRecv:
-			if (p.ProtocolRecvHook != nil) && !p.SendHook(msg) {
-				msg.Free()
-			} else {
+			if msg, ok := p.accept(msg); ok {
 				return msg
 			}
 		case <-p.Done():
 			return nil
+		case <-expired:
+			return nil
 		}
 	}
 }
 
+// accept an incoming message, returning false if it was dropped.  Since receive
+// hooks may modify the message, a shared message is replaced by a private copy
+// before they run.
+func (p *portal) accept(msg *Message) (*Message, bool) {
+	if msg != nil && msg.Expired() {
+		p.Drop(msg, ErrExpired)
+		return nil, false
+	}
+
+	if p.ProtocolRecvHook != nil {
+		if msg != nil {
+			msg = msg.own()
+		}
+
+		if !p.RecvHook(msg) {
+			p.Drop(msg, ErrFiltered)
+			return nil, false
+		}
+	}
+
+	if msg != nil {
+		p.stats.Recvd(msg.Value)
+	}
+
+	return msg, true
+}
+
 func (p *portal) Close() { p.cancel() }
 
+// Drop releases a message that could not be delivered
+func (p *portal) Drop(msg *Message, reason error) {
+	if reason == ErrExpired {
+		p.stats.Expired()
+	}
+
+	if reason != ErrClosed {
+		p.ack(msg)
+	}
+
+	p.stats.Dropped()
+	msg.dropped(reason)
+
+	if p.DeadLetter != "" {
+		p.deadLetter(msg, reason)
+	}
+
+	msg.Free()
+}
+
+// Stats returns a snapshot of the portal's activity
+func (p *portal) Stats() (s Stats) {
+	s = p.stats.Stats()
+
+	if p.sendPQ != nil {
+		s.SendQueue = p.sendPQ.Len()
+		s.RecvQueue = p.recvPQ.Len()
+	} else {
+		s.SendQueue = len(p.chSend)
+		s.RecvQueue = len(p.chRecv)
+	}
+
+	return
+}
+
 // Implement Endpoint
 func (p *portal) ID() ID { return p.id }
 
@@ -159,12 +636,58 @@ func (p *portal) ID() ID { return p.id }
 func (p *portal) Signature() ProtocolSignature { return p.proto }
 
 // Implement ProtocolSocket
-func (p *portal) SendChannel() <-chan *Message  { return p.chSend }
-func (p *portal) RecvChannel() chan<- *Message  { return p.chRecv }
+func (p *portal) SendChannel() <-chan *Message  { return p.sendQ }
+func (p *portal) RecvChannel() chan<- *Message  { return p.recvQ }
 func (p *portal) CloseChannel() <-chan struct{} { return p.Done() }
 
 // gc manages the lifecycle of an endpoint in the background
 func (p *portal) ConnectEndpoint(ep Endpoint) {
+	c, disconnect := ctx.WithCancel(ctx.Link(p, ep))
+
+	p.connLock.Lock()
+	p.conns[ep.ID()] = conn{ep: ep, cancel: disconnect}
+	p.connLock.Unlock()
+
 	p.proto.AddEndpoint(ep)
-	ctx.Defer(ctx.Link(p, ep), func() { p.proto.RemoveEndpoint(ep) })
+	p.stats.PeerAdded()
+	p.peerEvent(PeerAdded, ep)
+
+	ctx.Defer(c, func() {
+		p.connLock.Lock()
+		delete(p.conns, ep.ID())
+		p.connLock.Unlock()
+
+		p.proto.RemoveEndpoint(ep)
+		p.stats.PeerRemoved()
+		p.peerEvent(PeerRemoved, ep)
+	})
+}
+
+// conn is the connection to a peer
+type conn struct {
+	ep     Endpoint // as passed to ConnectEndpoint, i.e. unwrapped
+	cancel func()
+}
+
+// disconnect both ends of the connection to a peer, without closing either
+// portal
+func (p *portal) disconnect(id ID) {
+	if c, ok := p.hangUp(id); ok {
+		if peer, ok := c.ep.(*portal); ok {
+			peer.hangUp(p.id)
+		}
+	}
+}
+
+// hangUp cancels this portal's end of the connection to a peer
+func (p *portal) hangUp(id ID) (c conn, ok bool) {
+	p.connLock.Lock()
+	c, ok = p.conns[id]
+	p.connLock.Unlock()
+
+	if ok {
+		c.cancel()
+	}
+
+	return
 }
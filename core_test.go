@@ -29,6 +29,19 @@ func TestMkPortal(t *testing.T) {
 		}
 	})
 
+	t.Run("CfgDoner", func(t *testing.T) {
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+
+		ptl := MakePortal(Cfg{Doner: d}, proto).(*portal)
+		cancel()
+
+		select {
+		case <-ptl.Done():
+		case <-time.After(time.Millisecond * 100):
+			t.Error("portal not closed along with Cfg.Doner")
+		}
+	})
+
 	t.Run("Sync", func(t *testing.T) {
 		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
 		defer cancel()
@@ -67,6 +80,28 @@ func TestMkPortal(t *testing.T) {
 	})
 }
 
+func TestBindConnectErrors(t *testing.T) {
+	const addr = "/test/core/errors"
+
+	p0 := MakePortal(Cfg{}, mockProto{})
+	defer p0.Close()
+
+	if err := p0.Connect(addr); err == nil {
+		t.Error("expected error connecting to unbound address")
+	}
+
+	if err := p0.Bind(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	p1 := MakePortal(Cfg{}, mockProto{})
+	defer p1.Close()
+
+	if err := p1.Bind(addr); err == nil {
+		t.Error("expected error binding to an address in use")
+	}
+}
+
 func TestTransportIntegration(t *testing.T) {
 	// BINDING PORTAL
 	bindEPAdded := make(chan Endpoint)
@@ -337,3 +372,89 @@ func TestSendRecvClose(t *testing.T) {
 	})
 
 }
+
+func TestTTL(t *testing.T) {
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	ptl := newPortal(mockProto{}, Cfg{Doner: d, Size: 2, TTL: time.Millisecond}, cancel)
+	defer ptl.Close()
+
+	ptl.setRunning()
+	ptl.Send("stale")
+
+	msg := <-ptl.SendChannel()
+	if msg.Deadline.IsZero() {
+		t.Fatal("TTL did not set message deadline")
+	}
+
+	time.Sleep(time.Millisecond * 2)
+
+	fresh := NewMsg()
+	fresh.Value = "fresh"
+	ptl.chRecv <- msg
+	ptl.chRecv <- fresh
+
+	if v := ptl.Recv(); v != "fresh" {
+		t.Errorf("expected expired message to be skipped, got %v", v)
+	}
+
+	if s := ptl.Stats(); s.Expired != 1 || s.Dropped != 1 {
+		t.Errorf("expected one expired drop, got expired=%d dropped=%d", s.Expired, s.Dropped)
+	}
+}
+
+type mockCodec struct{}
+
+func (mockCodec) Encode(v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.Errorf("cannot encode %T", v)
+	}
+	return []byte(s), nil
+}
+func (mockCodec) Decode(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty")
+	}
+	return string(b), nil
+}
+
+func TestCodec(t *testing.T) {
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	ptl := newPortal(mockProto{}, Cfg{Doner: d, Size: 2, Codec: mockCodec{}}, cancel)
+	defer ptl.Close()
+
+	ptl.setRunning()
+	ptl.Send("hello")
+
+	msg := <-ptl.SendChannel()
+	if b, ok := msg.Value.([]byte); !ok || string(b) != "hello" {
+		t.Fatalf("expected encoded value, got %v", msg.Value)
+	}
+
+	bad := NewMsg()
+	bad.Value = []byte{}
+	ptl.chRecv <- bad
+	ptl.chRecv <- msg
+
+	if v := ptl.Recv(); v != "hello" {
+		t.Errorf("expected undecodable message to be skipped, got %v", v)
+	}
+
+	if n := ptl.Stats().Dropped; n != 1 {
+		t.Errorf("expected one dropped message, got %d", n)
+	}
+
+	t.Run("EncodeError", func(t *testing.T) {
+		if r := ptl.SendAsync(42).Receipt(); r.Err == nil || r.Dropped != 1 {
+			t.Errorf("expected unencodable value to be dropped, got %+v", r)
+		}
+
+		ptl.Send(42) // must not panic or block
+
+		select {
+		case msg := <-ptl.SendChannel():
+			t.Errorf("unencodable value was sent: %v", msg.Value)
+		default:
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package portal
+
+// Header keys set on dead letters (see Cfg.DeadLetter)
+const (
+	HeaderDropReason = "portal.drop-reason" // string
+	HeaderAddress    = "portal.address"     // string
+)
+
+// ProtocolDeadLetterSink is implemented by protocols whose portals may be bound
+// to a dead-letter address (see Cfg.DeadLetter), e.g. PULL.
+type ProtocolDeadLetterSink interface {
+	// DeadLetter queues a dead letter for the application.  It must not block,
+	// and returns false if the message could not be queued, in which case the
+	// caller retains ownership.
+	DeadLetter(*Message) bool
+}
+
+// deadLetter routes a copy of a dropped message to the dead-letter address.  If
+// no eligible portal is bound there, or if it is full, the message is
+// discarded.
+func (p *portal) deadLetter(msg *Message, reason error) {
+	ep, err := addrTable.Lookup(p.DeadLetter)
+	if err != nil || ep.ID() == p.id {
+		return
+	}
+
+	sink := ep.deadLetterSink()
+	if sink == nil {
+		return
+	}
+
+	why := "unknown"
+	if reason != nil {
+		why = reason.Error()
+	}
+
+	cp := msg.dup()
+	cp.Annotate(HeaderDropReason, why)
+	cp.Annotate(HeaderAddress, p.addr)
+	cp.detach()
+
+	if !sink.DeadLetter(cp) {
+		p.stats.Overflowed()
+		cp.Free()
+	}
+}
+
+// deadLetterSink returns nil if the portal's protocol does not accept dead
+// letters
+func (p *portal) deadLetterSink() ProtocolDeadLetterSink {
+	s, _ := p.proto.(ProtocolDeadLetterSink)
+	return s
+}
@@ -0,0 +1,112 @@
+package portal
+
+import (
+	"testing"
+	"time"
+)
+
+// sinkProto accepts dead letters
+type sinkProto struct {
+	mockProto
+	ptl ProtocolPortal
+}
+
+func (s *sinkProto) Init(ptl ProtocolPortal) { s.ptl = ptl }
+
+func (s *sinkProto) DeadLetter(msg *Message) bool {
+	select {
+	case s.ptl.RecvChannel() <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	dlq, _ := mkSendRecvTestPortal(new(sinkProto), 1)
+	defer dlq.Close()
+
+	if err := dlq.Bind("/test/deadletter/dlq"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	ptl, _ := mkSendRecvTestPortal(p, 1)
+	defer ptl.Close()
+	ptl.DeadLetter = "/test/deadletter/dlq"
+
+	if err := ptl.Bind("/test/deadletter/src"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewMsg()
+	msg.Value = "hello"
+	msg.Annotate("test.key", true)
+	ptl.Drop(msg, ErrExpired)
+
+	var d *Delivery
+	select {
+	case d = <-deliver(dlq):
+	case <-time.After(time.Second):
+		t.Fatal("dead letter was not delivered")
+	}
+	defer d.Ack()
+
+	if d.Value != "hello" {
+		t.Errorf("unexpected value %v", d.Value)
+	}
+
+	if r := d.Header[HeaderDropReason]; r != ErrExpired.Error() {
+		t.Errorf("unexpected drop reason %v", r)
+	}
+
+	if a := d.Header[HeaderAddress]; a != "/test/deadletter/src" {
+		t.Errorf("unexpected address %v", a)
+	}
+
+	if d.Header["test.key"] != true {
+		t.Error("original header was not preserved")
+	}
+
+	t.Run("Overflow", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 3; i++ { // nobody is receiving from the DLQ
+				ptl.Drop(NewMsg(), ErrExpired)
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Drop blocked on a full dead-letter portal")
+		}
+
+		if n := ptl.Stats().DeadLetterOverflow; n != 2 {
+			t.Errorf("expected 2 discarded dead letters, got %d", n)
+		}
+	})
+
+	t.Run("Ineligible", func(t *testing.T) {
+		src, _ := mkSendRecvTestPortal(p, 1)
+		defer src.Close()
+		src.DeadLetter = "/test/deadletter/src" // not a dead-letter sink
+
+		src.Drop(NewMsg(), ErrExpired)
+
+		if n := src.Stats().DeadLetterOverflow; n != 0 {
+			t.Errorf("expected dead letter to be ignored, got %d discarded", n)
+		}
+	})
+}
+
+func deliver(p *portal) <-chan *Delivery {
+	ch := make(chan *Delivery, 1)
+	go func() { ch <- p.RecvDelivery() }()
+	return ch
+}
@@ -0,0 +1,156 @@
+package portal
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultAckTimeout is used when Cfg.AckTimeout is zero
+const DefaultAckTimeout = time.Second * 30
+
+var (
+	// ErrNacked is the reason given when a delivery is rejected without being
+	// requeued.
+	ErrNacked = errors.New("message rejected")
+
+	// ErrSettled is returned when acknowledging a delivery that was already
+	// acknowledged, rejected, or whose visibility timeout elapsed.
+	ErrSettled = errors.New("delivery already settled")
+)
+
+// ProtocolRequeuer is implemented by protocols that can redeliver a message
+// that was rejected by the application, or whose visibility timeout elapsed.
+// If Requeue returns false, the portal retains ownership of the message and
+// redelivers it locally.
+type ProtocolRequeuer interface {
+	Requeue(*Message) bool
+}
+
+// ProtocolRedeliverer is implemented by protocols that accept messages handed
+// back by a peer, e.g. a PUSH protocol that delivers a value to another PULL
+// peer when it is not acknowledged.  It is invoked through Endpoint.Redeliver.
+// If Redeliver returns false, the caller retains ownership of the message.
+type ProtocolRedeliverer interface {
+	Redeliver(msg *Message, from ID) bool
+}
+
+// Delivery is a value received in manual-ack mode.  Exactly one of Ack or Nack
+// should be called.  If neither is called before the portal's AckTimeout
+// elapses, the value is requeued.
+type Delivery struct {
+	Value  interface{}
+	Header Header // a copy of the message header, if any
+
+	ptl     *portal
+	msg     *Message
+	timer   *time.Timer
+	settled int32
+}
+
+func (d *Delivery) settle() bool {
+	if !atomic.CompareAndSwapInt32(&d.settled, 0, 1) {
+		return false
+	}
+
+	d.timer.Stop()
+	return true
+}
+
+// Ack acknowledges that the value was processed
+func (d *Delivery) Ack() error {
+	if !d.settle() {
+		return ErrSettled
+	}
+
+	d.ptl.consume(d.msg)
+	return nil
+}
+
+// Nack rejects the value.  If requeue is true, the value is redelivered,
+// ideally to another portal.  Otherwise it is dropped.
+func (d *Delivery) Nack(requeue bool) error {
+	if !d.settle() {
+		return ErrSettled
+	}
+
+	if requeue {
+		go d.ptl.requeue(d.msg)
+	} else {
+		d.ptl.Drop(d.msg, ErrNacked)
+	}
+
+	return nil
+}
+
+func (d *Delivery) expire() {
+	if atomic.CompareAndSwapInt32(&d.settled, 0, 1) {
+		d.ptl.requeue(d.msg)
+	}
+}
+
+// RecvDelivery receives a value in manual-ack mode
+func (p *portal) RecvDelivery() *Delivery {
+	msg, v := p.recv()
+	if msg == nil {
+		return nil
+	}
+
+	timeout := p.AckTimeout
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	d := &Delivery{Value: v, Header: msg.Header.clone(), ptl: p, msg: msg}
+
+	d.timer = time.AfterFunc(timeout, d.expire)
+	return d
+}
+
+// Redeliver implements Endpoint.Redeliver
+func (p *portal) Redeliver(msg *Message, from ID) bool {
+	r, ok := p.proto.(ProtocolRedeliverer)
+	return ok && r.Redeliver(msg, from)
+}
+
+// requeue a message that was not acknowledged.  Protocols that do not implement
+// ProtocolRequeuer redeliver the message to the same portal.
+func (p *portal) requeue(msg *Message) {
+	if p.handOff(msg) {
+		return
+	}
+
+	select {
+	case p.redeliverQ <- msg:
+	case <-p.Done():
+		p.Drop(msg, ErrClosed)
+	}
+}
+
+// handOff passes a message to the protocol for redelivery.  It returns false if
+// the protocol is not a ProtocolRequeuer or declined the message, in which case
+// the portal retains ownership of it.
+func (p *portal) handOff(msg *Message) bool {
+	r, ok := p.proto.(ProtocolRequeuer)
+	if !ok {
+		return false
+	}
+
+	// The sequence number refers to this portal's receive log, which no longer
+	// holds the message once it is handed over.  The entry is removed
+	// afterwards, so that a crash in between redelivers the value rather than
+	// losing it.
+	seq := msg.seq
+	msg.seq = 0
+
+	if !r.Requeue(msg) {
+		msg.seq = seq
+		return false
+	}
+
+	if p.RecvLog != nil && seq != 0 {
+		_ = p.RecvLog.Ack(seq)
+	}
+	return true
+}
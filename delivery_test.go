@@ -0,0 +1,105 @@
+package portal
+
+import (
+	"testing"
+	"time"
+)
+
+func mkDeliveryTestPortal(timeout time.Duration) *portal {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	ptl, _ := mkSendRecvTestPortal(p, 1)
+	ptl.AckTimeout = timeout
+	ptl.setRunning()
+	return ptl
+}
+
+func TestDelivery(t *testing.T) {
+	t.Run("Ack", func(t *testing.T) {
+		ptl := mkDeliveryTestPortal(time.Minute)
+		defer ptl.Close()
+
+		f := ptl.SendAsync("hello")
+		ptl.chRecv <- <-ptl.chSend
+
+		d := ptl.RecvDelivery()
+		if d.Value != "hello" {
+			t.Errorf("unexpected value %v", d.Value)
+		}
+
+		select {
+		case <-f.Done():
+			t.Fatal("future resolved before delivery was acknowledged")
+		case <-time.After(time.Millisecond):
+		}
+
+		if err := d.Ack(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Ack(); err != ErrSettled {
+			t.Errorf("expected ErrSettled, got %v", err)
+		}
+
+		if r := f.Receipt(); len(r.Recipients) != 1 {
+			t.Errorf("expected 1 recipient, got %d", len(r.Recipients))
+		}
+	})
+
+	t.Run("Nack", func(t *testing.T) {
+		ptl := mkDeliveryTestPortal(time.Minute)
+		defer ptl.Close()
+
+		f := ptl.SendAsync("hello")
+		ptl.chRecv <- <-ptl.chSend
+
+		if err := ptl.RecvDelivery().Nack(false); err != nil {
+			t.Fatal(err)
+		}
+
+		if r := f.Receipt(); r.Err != ErrNacked {
+			t.Errorf("expected ErrNacked, got %v", r.Err)
+		}
+	})
+
+	t.Run("Requeue", func(t *testing.T) {
+		ptl := mkDeliveryTestPortal(time.Minute)
+		defer ptl.Close()
+
+		ptl.SendAsync("hello")
+		ptl.chRecv <- <-ptl.chSend
+
+		if err := ptl.RecvDelivery().Nack(true); err != nil {
+			t.Fatal(err)
+		}
+
+		d := ptl.RecvDelivery()
+		if d.Value != "hello" {
+			t.Errorf("unexpected value %v", d.Value)
+		}
+		d.Ack()
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		ptl := mkDeliveryTestPortal(time.Millisecond)
+		defer ptl.Close()
+
+		ptl.SendAsync("hello")
+		ptl.chRecv <- <-ptl.chSend
+
+		d0 := ptl.RecvDelivery()
+		d1 := ptl.RecvDelivery() // redelivered after timeout
+
+		if err := d0.Ack(); err != ErrSettled {
+			t.Errorf("expected ErrSettled, got %v", err)
+		}
+
+		if d1.Value != "hello" {
+			t.Errorf("unexpected value %v", d1.Value)
+		}
+		d1.Ack()
+	})
+}
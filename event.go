@@ -0,0 +1,57 @@
+package portal
+
+import "github.com/pkg/errors"
+
+// ErrRejected is returned by Connect if either portal's Cfg.OnPeer hook
+// rejected the other.
+var ErrRejected = errors.New("peer rejected")
+
+// PeerEventType identifies a change in a portal's peers
+type PeerEventType uint8
+
+const (
+	// PeerAdding is reported before a peer is added.  The peer is rejected if
+	// the hook returns false.
+	PeerAdding PeerEventType = iota
+
+	// PeerAdded is reported once the peer was added to the protocol
+	PeerAdded
+
+	// PeerRemoved is reported once the peer was removed from the protocol
+	PeerRemoved
+)
+
+func (t PeerEventType) String() string {
+	switch t {
+	case PeerAdding:
+		return "adding"
+	case PeerAdded:
+		return "added"
+	case PeerRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerEvent describes a change in a portal's peers (see Cfg.OnPeer)
+type PeerEvent struct {
+	Type      PeerEventType
+	Peer      ID
+	Signature ProtocolSignature // of the peer's protocol
+}
+
+// admit returns false if Cfg.OnPeer rejects the peer
+func (p *portal) admit(ep Endpoint) bool {
+	return p.OnPeer == nil || p.OnPeer(PeerEvent{
+		Type:      PeerAdding,
+		Peer:      ep.ID(),
+		Signature: ep.Signature(),
+	})
+}
+
+func (p *portal) peerEvent(t PeerEventType, ep Endpoint) {
+	if p.OnPeer != nil {
+		p.OnPeer(PeerEvent{Type: t, Peer: ep.ID(), Signature: ep.Signature()})
+	}
+}
@@ -0,0 +1,79 @@
+package portal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func mkEventTestProto() mockProto {
+	return mockProto{
+		mockProtoSig: mockProtoSig{name: "mock", peerName: "mock"},
+		epAdded:      make(chan Endpoint, 1),
+		epRemoved:    make(chan Endpoint, 1),
+	}
+}
+
+func TestPeerEvents(t *testing.T) {
+	t.Run("AddRemove", func(t *testing.T) {
+		events := make(chan PeerEvent, 3)
+		onPeer := func(ev PeerEvent) bool {
+			events <- ev
+			return true
+		}
+
+		b := MakePortal(Cfg{OnPeer: onPeer}, mkEventTestProto())
+		defer b.Close()
+		if err := b.Bind("/test/event/addremove"); err != nil {
+			t.Fatal(err)
+		}
+
+		c := MakePortal(Cfg{}, mkEventTestProto()).(*portal)
+		if err := c.Connect("/test/event/addremove"); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Close()
+
+		for _, typ := range []PeerEventType{PeerAdding, PeerAdded, PeerRemoved} {
+			select {
+			case ev := <-events:
+				if ev.Type != typ {
+					t.Errorf("expected %s event, got %s", typ, ev.Type)
+				}
+
+				if ev.Peer != c.ID() {
+					t.Errorf("unexpected peer %s", ev.Peer)
+				}
+
+				if ev.Signature.Name() != "mock" {
+					t.Errorf("unexpected signature %s", ev.Signature.Name())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s event not reported", typ)
+			}
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		reject := func(ev PeerEvent) bool { return ev.Type != PeerAdding }
+
+		b := MakePortal(Cfg{OnPeer: reject}, mkEventTestProto())
+		defer b.Close()
+		if err := b.Bind("/test/event/reject"); err != nil {
+			t.Fatal(err)
+		}
+
+		c := MakePortal(Cfg{}, mkEventTestProto())
+		defer c.Close()
+
+		if err := c.Connect("/test/event/reject"); errors.Cause(err) != ErrRejected {
+			t.Errorf("expected ErrRejected, got %v", err)
+		}
+
+		if n := b.Stats().Peers; n != 0 {
+			t.Errorf("expected 0 peers, got %d", n)
+		}
+	})
+}
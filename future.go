@@ -0,0 +1,73 @@
+package portal
+
+import "sync"
+
+// Receipt describes the outcome of an asynchronous send
+type Receipt struct {
+	Recipients []ID  // portals that received the value
+	Dropped    int   // number of deliveries that were abandoned
+	Err        error // reason given for the first drop, if any
+}
+
+// Future resolves once every recipient of a message sent with SendAsync has
+// consumed it, or the message was dropped.
+type Future interface {
+	// Done is closed when the future resolves
+	Done() <-chan struct{}
+
+	// Receipt blocks until the future resolves
+	Receipt() Receipt
+}
+
+type future struct {
+	sync.Mutex
+	r    Receipt
+	done chan struct{}
+
+	seq   uint64 // send log entry
+	log   Log
+	retry bool // set if a delivery was abandoned because a portal closed
+}
+
+func newFuture() *future { return &future{done: make(chan struct{})} }
+
+func (f *future) Done() <-chan struct{} { return f.done }
+
+func (f *future) Receipt() Receipt {
+	<-f.done
+	return f.r
+}
+
+func (f *future) delivered(id ID) {
+	f.Lock()
+	f.r.Recipients = append(f.r.Recipients, id)
+	f.Unlock()
+}
+
+func (f *future) dropped(reason error) {
+	f.Lock()
+	f.r.Dropped++
+	f.retry = f.retry || reason == ErrClosed
+	if f.r.Err == nil {
+		f.r.Err = reason
+	}
+	f.Unlock()
+}
+
+func (f *future) resolve() {
+	// Remove the value from the send log unless it should be sent again.
+	// Failure is tolerable, since the value is merely delivered again when
+	// the log is replayed.
+	if f.log != nil && (len(f.r.Recipients) > 0 || !f.retry) {
+		_ = f.log.Ack(f.seq)
+	}
+
+	close(f.done)
+}
+
+// settle is the completion callback for messages sent with SendAsync
+func settle(m *Message) {
+	f := m.receipt
+	msgPool.Put(m)
+	f.resolve()
+}
@@ -0,0 +1,54 @@
+package portal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendAsync(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	ptl, _ := mkSendRecvTestPortal(p, 1)
+	defer ptl.Close()
+	ptl.setRunning()
+
+	f := ptl.SendAsync("hello")
+
+	msg := <-ptl.chSend
+	cp := msg.Share()
+
+	ptl.chRecv <- msg
+	if v := ptl.Recv(); v != "hello" {
+		t.Errorf("unexpected value %v", v)
+	}
+
+	select {
+	case <-f.Done():
+		t.Fatal("future resolved before all recipients were done")
+	case <-time.After(time.Millisecond):
+	}
+
+	ptl.Drop(cp, ErrExpired)
+
+	select {
+	case <-f.Done():
+	case <-time.After(time.Second):
+		t.Fatal("future did not resolve")
+	}
+
+	r := f.Receipt()
+	if len(r.Recipients) != 1 || r.Recipients[0] != ptl.ID() {
+		t.Errorf("unexpected recipients %v", r.Recipients)
+	}
+
+	if r.Dropped != 1 {
+		t.Errorf("expected 1 drop, got %d", r.Dropped)
+	}
+
+	if r.Err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", r.Err)
+	}
+}
@@ -0,0 +1,76 @@
+package portal
+
+import (
+	"iter"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/pkg/errors"
+)
+
+// Guard restricts a portal to its Transporter methods.  Protocols embed it, or
+// ReadGuard and WriteGuard, to add methods of their own to a portal without
+// exposing the rest of it.
+type Guard struct{ t Transporter }
+
+// NewGuard restricts a portal to its Transporter methods
+func NewGuard(t Transporter) Guard { return Guard{t} }
+
+func (g Guard) Connect(addr string) error { return g.t.Connect(addr) }
+func (g Guard) Bind(addr string) error    { return g.t.Bind(addr) }
+func (g Guard) Close()                    { g.t.Close() }
+func (g Guard) Stats() Stats              { return g.t.Stats() }
+func (g Guard) unwrap() Transporter       { return g.t }
+
+// ReadGuard restricts a portal to its ReadOnly methods
+type ReadGuard struct {
+	Guard
+	r ReadOnly
+}
+
+// NewReadGuard restricts a portal to its ReadOnly methods
+func NewReadGuard(r ReadOnly) ReadGuard { return ReadGuard{Guard{r}, r} }
+
+func (g ReadGuard) Recv() interface{}                     { return g.r.Recv() }
+func (g ReadGuard) RecvOK() (interface{}, bool)           { return g.r.RecvOK() }
+func (g ReadGuard) RecvHeader() (interface{}, Header)     { return g.r.RecvHeader() }
+func (g ReadGuard) All(d ctx.Doner) iter.Seq[interface{}] { return g.r.All(d) }
+
+// WriteGuard restricts a portal to its WriteOnly methods
+type WriteGuard struct {
+	Guard
+	w WriteOnly
+}
+
+// NewWriteGuard restricts a portal to its WriteOnly methods
+func NewWriteGuard(w WriteOnly) WriteGuard { return WriteGuard{Guard{w}, w} }
+
+func (g WriteGuard) Send(v interface{})                   { g.w.Send(v) }
+func (g WriteGuard) SendPriority(v interface{}, prio int) { g.w.SendPriority(v, prio) }
+func (g WriteGuard) SendHeader(v interface{}, h Header)   { g.w.SendHeader(v, h) }
+func (g WriteGuard) SendAsync(v interface{}) Future       { return g.w.SendAsync(v) }
+
+// unwrapper is implemented by guards
+type unwrapper interface {
+	unwrap() Transporter
+}
+
+// corer is implemented by portals allocated by MakePortal
+type corer interface {
+	core() *portal
+}
+
+func (p *portal) core() *portal { return p }
+
+// coreOf returns the portal underlying t, unwrapping it if necessary
+func coreOf(t Transporter) *portal {
+	for {
+		switch x := t.(type) {
+		case corer:
+			return x.core()
+		case unwrapper:
+			t = x.unwrap()
+		default:
+			panic(errors.Errorf("%T was not allocated by MakePortal", t))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package portal
+
+import "testing"
+
+func TestGuard(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	ptl, _ := mkSendRecvTestPortal(p, 1)
+	defer ptl.Close()
+
+	var r ReadOnly = NewReadGuard(ptl)
+	if _, ok := r.(WriteOnly); ok {
+		t.Error("ReadGuard exposes WriteOnly methods")
+	}
+
+	var w WriteOnly = NewWriteGuard(ptl)
+	if _, ok := w.(ReadOnly); ok {
+		t.Error("WriteGuard exposes ReadOnly methods")
+	}
+
+	if coreOf(r) != ptl || coreOf(w) != ptl {
+		t.Error("guard does not unwrap to the portal")
+	}
+}
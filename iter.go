@@ -0,0 +1,53 @@
+package portal
+
+import (
+	"iter"
+	"reflect"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/pkg/errors"
+)
+
+// All returns an iterator over the values received by the portal.  Iteration
+// ends when the portal is closed, or when d expires.  A nil d never expires.
+func (p *portal) All(d ctx.Doner) iter.Seq[interface{}] {
+	var expired <-chan struct{}
+	if d != nil {
+		expired = d.Done()
+	}
+
+	return func(yield func(interface{}) bool) {
+		if !p.running() {
+			return
+		}
+
+		for {
+			msg, v := p.recvUntil(expired)
+			if msg == nil {
+				return
+			}
+
+			p.consume(msg)
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// AllOf is like ReadOnly.All, but yields values of type T.  It panics if the
+// portal receives a value of another type.
+func AllOf[T any](r ReadOnly, d ctx.Doner) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for v := range r.All(d) {
+			t, ok := v.(T)
+			if !ok && v != nil {
+				panic(errors.Errorf("expected %s, got %T", reflect.TypeFor[T](), v))
+			}
+
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
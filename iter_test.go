@@ -0,0 +1,115 @@
+package portal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+)
+
+func TestIter(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	mkPortal := func() *portal {
+		ptl, _ := mkSendRecvTestPortal(p, 3)
+		ptl.setRunning()
+		return ptl
+	}
+
+	deliver := func(ptl *portal, vs ...interface{}) {
+		for _, v := range vs {
+			msg := NewMsg()
+			msg.Value = v
+			msg.detach()
+			ptl.chRecv <- msg
+		}
+	}
+
+	t.Run("RecvOK", func(t *testing.T) {
+		ptl := mkPortal()
+		deliver(ptl, nil)
+
+		if v, ok := ptl.RecvOK(); v != nil || !ok {
+			t.Errorf("unexpected result (%v, %t)", v, ok)
+		}
+
+		ptl.Close()
+		if v, ok := ptl.RecvOK(); v != nil || ok {
+			t.Errorf("unexpected result (%v, %t)", v, ok)
+		}
+	})
+
+	t.Run("All", func(t *testing.T) {
+		ptl := mkPortal()
+		deliver(ptl, "a", nil, "c")
+		time.AfterFunc(time.Millisecond*10, ptl.Close)
+
+		var got []interface{}
+		for v := range ptl.All(nil) {
+			got = append(got, v)
+		}
+
+		if len(got) != 3 || got[0] != "a" || got[1] != nil || got[2] != "c" {
+			t.Errorf("unexpected values %v", got)
+		}
+	})
+
+	t.Run("Break", func(t *testing.T) {
+		ptl := mkPortal()
+		defer ptl.Close()
+
+		deliver(ptl, "a", "b")
+		for range ptl.All(nil) {
+			break
+		}
+
+		if v := ptl.Recv(); v != "b" {
+			t.Errorf("expected b, got %v", v)
+		}
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		ptl := mkPortal()
+		defer ptl.Close()
+
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		time.AfterFunc(time.Millisecond, cancel)
+
+		for v := range ptl.All(d) {
+			t.Errorf("unexpected value %v", v)
+		}
+	})
+
+	t.Run("AllOf", func(t *testing.T) {
+		ptl := mkPortal()
+		deliver(ptl, 1, 2)
+		time.AfterFunc(time.Millisecond*10, ptl.Close)
+
+		var sum int
+		for i := range AllOf[int](ptl, nil) {
+			sum += i
+		}
+
+		if sum != 3 {
+			t.Errorf("expected 3, got %d", sum)
+		}
+	})
+
+	t.Run("AllOfMismatch", func(t *testing.T) {
+		ptl := mkPortal()
+		defer ptl.Close()
+
+		deliver(ptl, "a")
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic")
+			}
+		}()
+
+		for range AllOf[int](ptl, nil) {
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package portal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+)
+
+// Limit configures a token-bucket rate limiter.  The zero value imposes no
+// limit.
+type Limit struct {
+	Rate  float64 // sustained rate, in messages per second
+	Burst int     // maximum number of messages allowed at once; defaults to 1
+}
+
+// Unlimited returns true if the Limit does not throttle anything
+func (l Limit) Unlimited() bool { return l.Rate <= 0 }
+
+// Limiter throttles message throughput using a token bucket.  A nil Limiter
+// never blocks.
+type Limiter struct {
+	sync.Mutex
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+// NewLimiter allocates a Limiter.  It returns nil if the Limit is Unlimited.
+func NewLimiter(l Limit) *Limiter {
+	if l.Unlimited() {
+		return nil
+	}
+
+	burst := float64(l.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		rate:   l.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve a token, returning the time to wait before it may be used
+func (l *Limiter) reserve() time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if l.tokens += now.Sub(l.last).Seconds() * l.rate; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens--; l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *Limiter) cancel() {
+	l.Lock()
+	l.tokens++
+	l.Unlock()
+}
+
+// Wait blocks until a message may be sent.  It returns false if the Doner
+// fired before then.
+func (l *Limiter) Wait(d ctx.Doner) bool {
+	if l == nil {
+		return true
+	}
+
+	delay := l.reserve()
+	if delay == 0 {
+		return true
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-d.Done():
+		l.cancel()
+		return false
+	}
+}
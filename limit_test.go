@@ -0,0 +1,90 @@
+package portal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("Unlimited", func(t *testing.T) {
+		if l := NewLimiter(Limit{}); l != nil {
+			t.Error("zero-value Limit should not allocate a Limiter")
+		}
+
+		var l *Limiter
+		if !l.Wait(ctx.Lift(make(chan struct{}))) {
+			t.Error("nil Limiter should never block")
+		}
+	})
+
+	t.Run("Burst", func(t *testing.T) {
+		l := NewLimiter(Limit{Rate: 1, Burst: 3})
+		d := ctx.Lift(make(chan struct{}))
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			l.Wait(d)
+		}
+
+		if time.Since(start) > time.Millisecond*10 {
+			t.Error("burst was throttled")
+		}
+	})
+
+	t.Run("Throttle", func(t *testing.T) {
+		l := NewLimiter(Limit{Rate: 100})
+		d := ctx.Lift(make(chan struct{}))
+
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			l.Wait(d)
+		}
+
+		if elapsed := time.Since(start); elapsed < time.Millisecond*45 {
+			t.Errorf("expected throttling to ~50ms, took %s", elapsed)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		l := NewLimiter(Limit{Rate: 0.001})
+		l.Wait(ctx.Lift(make(chan struct{}))) // drain the bucket
+
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		ch := make(chan bool)
+		go func() { ch <- l.Wait(d) }()
+
+		cancel()
+
+		select {
+		case ok := <-ch:
+			if ok {
+				t.Error("Wait should return false when the Doner fires")
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Error("Wait did not return after the Doner fired")
+		}
+	})
+}
+
+func TestSendLimit(t *testing.T) {
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	ptl := newPortal(mockProto{}, Cfg{
+		Doner:     d,
+		Size:      8,
+		SendLimit: Limit{Rate: 100},
+	}, cancel)
+	defer ptl.Close()
+
+	ptl.setRunning()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		ptl.Send(i)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Millisecond*25 {
+		t.Errorf("expected throttling to ~30ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,115 @@
+package portal
+
+import "github.com/pkg/errors"
+
+// Log is a durable record of messages awaiting acknowledgement.  It allows
+// a portal to survive process restarts:  see Cfg.SendLog and Cfg.RecvLog.
+// Package wal provides a disk-backed implementation.
+//
+// Delivery is at-least-once.  A value that was delivered shortly before a
+// crash may be delivered again when its log is replayed.
+type Log interface {
+	// Append records a value and returns its sequence number, which is never
+	// zero.
+	Append(interface{}) (uint64, error)
+
+	// Ack removes a value from the log
+	Ack(uint64) error
+
+	// Pending returns the values that have not been acknowledged, in the order
+	// in which they were appended.
+	Pending() ([]LogEntry, error)
+}
+
+// LogEntry is a value recorded in a Log
+type LogEntry struct {
+	Seq   uint64
+	Value interface{}
+}
+
+// replaySendLog re-sends the values that were not delivered before the portal
+// last shut down.
+func (p *portal) replaySendLog() {
+	es, err := p.SendLog.Pending()
+	if err != nil {
+		p.fail(errors.Wrap(err, "replay send log"))
+		return
+	}
+
+	for _, e := range es {
+		f := newFuture()
+		f.seq = e.Seq
+		p.transmit(e.Value, 0, nil, f)
+	}
+}
+
+// logRecv persists incoming messages to the receive log, then passes them on to
+// the portal.  Values that were not consumed before the portal last shut down
+// are replayed first.  If they cannot be read, the error is reported and only
+// new messages are passed on.
+func (p *portal) logRecv(in <-chan *Message, out chan<- *Message) {
+	es, err := p.RecvLog.Pending()
+	if err != nil {
+		p.fail(errors.Wrap(err, "replay recv log"))
+	}
+
+	for _, e := range es {
+		msg := NewMsg()
+		msg.Value = e.Value
+		msg.seq = e.Seq
+		msg.detach()
+
+		if !p.forward(msg, out) {
+			return
+		}
+	}
+
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				return // protocol closed its receive channel (e.g. PUSH)
+			}
+
+			seq, err := p.RecvLog.Append(msg.Value)
+			if err != nil {
+				p.Drop(msg, errors.Wrap(err, "recv log"))
+				continue
+			}
+
+			// The message is now durable, so it counts as delivered.  It may
+			// be shared with other receivers, so copy it rather than tagging it
+			// with the sequence number.
+			cp := msg.dup()
+			cp.seq = seq
+			cp.detach()
+
+			msg.delivered(p.id)
+			msg.Free()
+
+			if !p.forward(cp, out) {
+				return
+			}
+		case <-p.Done():
+			return
+		}
+	}
+}
+
+func (p *portal) forward(msg *Message, out chan<- *Message) bool {
+	select {
+	case out <- msg:
+		return true
+	case <-p.Done():
+		msg.Free() // left in the log, to be replayed
+		return false
+	}
+}
+
+// ack removes a consumed message from the receive log.  Failure is tolerable,
+// since the value is merely delivered again when the log is replayed.
+func (p *portal) ack(msg *Message) {
+	if p.RecvLog != nil && msg.seq != 0 {
+		_ = p.RecvLog.Ack(msg.seq)
+	}
+}
@@ -0,0 +1,59 @@
+package portal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/pkg/errors"
+)
+
+var errBrokenLog = errors.New("broken log")
+
+// brokenLog fails every operation
+type brokenLog struct{}
+
+func (brokenLog) Append(interface{}) (uint64, error) { return 0, errBrokenLog }
+func (brokenLog) Ack(uint64) error                   { return errBrokenLog }
+func (brokenLog) Pending() ([]LogEntry, error)       { return nil, errBrokenLog }
+
+func TestLogFailure(t *testing.T) {
+	errs := make(chan error, 2)
+
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	defer cancel()
+
+	ptl := newPortal(mockProto{}, Cfg{
+		Doner:   d,
+		Size:    1,
+		SendLog: brokenLog{},
+		RecvLog: brokenLog{},
+		OnError: func(err error) { errs <- err },
+	}, cancel)
+
+	ptl.setRunning() // replays the send log
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if errors.Cause(err) != errBrokenLog {
+				t.Errorf("unexpected error %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("log failure not reported")
+		}
+	}
+
+	r := ptl.SendAsync("hello").Receipt()
+	if r.Dropped != 1 || errors.Cause(r.Err) != errBrokenLog {
+		t.Errorf("expected value to be dropped, got %+v", r)
+	}
+
+	if n := ptl.Stats().Dropped; n != 1 {
+		t.Errorf("expected 1 drop, got %d", n)
+	}
+
+	if n := len(ptl.chSend); n != 0 {
+		t.Errorf("expected nothing to be sent, got %d messages", n)
+	}
+}
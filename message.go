@@ -2,47 +2,222 @@ package portal
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	msgPool = messagePool{
-		Pool: sync.Pool{New: func() interface{} { return new(Message).Ref() }},
+		Pool: sync.Pool{New: func() interface{} {
+			return &Message{sig: make(chan struct{}, 1)}
+		}},
 	}
 )
 
 type messagePool struct{ sync.Pool }
 
-func (pool *messagePool) Get() *Message    { return pool.Pool.Get().(*Message) }
-func (pool *messagePool) Put(msg *Message) { go pool.put(msg) }
-func (pool *messagePool) put(msg *Message) {
+func (pool *messagePool) Get() *Message { return pool.Pool.Get().(*Message) }
+func (pool *messagePool) Put(msg *Message) {
+	if !recyclable(msg) {
+		return
+	}
+
 	msg.From = nil
+	msg.Value = nil
+	msg.Priority = 0
+	msg.Deadline = time.Time{}
+	msg.clone = nil
+	msg.done = nil
+	msg.orig = nil
+	msg.receipt = nil
+	msg.seq = 0
+	for k := range msg.Header {
+		delete(msg.Header, k)
+	}
 	pool.Pool.Put(msg)
 }
 
+// Completion callbacks, called when the last reference to a message is freed.
+// They are plain functions rather than closures so that setting one does not
+// allocate.
+
+// notify wakes the goroutine blocked in wait
+func notify(m *Message) { m.sig <- struct{}{} }
+
+// recycle returns the message to the pool.  It is used when nobody is waiting
+// for the message to be delivered.
+func recycle(m *Message) { msgPool.Put(m) }
+
+// unshare recycles a copy made by Share, and releases the original
+func unshare(m *Message) {
+	orig := m.orig
+	msgPool.Put(m)
+	orig.Free()
+}
+
+// Header holds annotations that accompany a message.  Keys should be
+// namespaced to avoid collisions, e.g. "auth.principal".
+type Header map[string]interface{}
+
+// clone returns a copy of the header, or nil if it is empty
+func (h Header) clone() Header {
+	if len(h) == 0 {
+		return nil
+	}
+
+	cp := make(Header, len(h))
+	for k, v := range h {
+		cp[k] = v
+	}
+	return cp
+}
+
 // Message wraps a value and sends it down the portal
 type Message struct {
-	wg    sync.WaitGroup
-	From  *ID
-	Value interface{}
+	refcnt   int32
+	From     *ID
+	Header   Header
+	Priority int       // higher values are delivered first
+	Deadline time.Time // zero if the message never expires
+	Value    interface{}
+
+	clone func(interface{}) interface{} // set if the sender wants copy-on-send
+	done  func(*Message)                // completion callback
+	sig   chan struct{}                 // signalled by notify
+	orig  *Message                      // message copied by Share
+
+	receipt *future // set by SendAsync
+	seq     uint64  // receive log entry
+	refs    *refLog // only used by portaldebug builds
+}
+
+// Expired returns true if the message's deadline has passed
+func (m *Message) Expired() bool {
+	return !m.Deadline.IsZero() && time.Now().After(m.Deadline)
+}
+
+// Annotate sets a header value, allocating the header if needed
+func (m *Message) Annotate(key string, v interface{}) {
+	if m.Header == nil {
+		m.Header = make(Header)
+	}
+	m.Header[key] = v
 }
 
 // Free deallocates a message
-func (m *Message) Free() { m.wg.Done() }
+func (m *Message) Free() {
+	m.debugFree()
+
+	switch n := atomic.AddInt32(&m.refcnt, -1); {
+	case n == 0:
+		m.done(m)
+	case n < 0:
+		panic("portal: message freed more times than it was referenced")
+	}
+}
 
 // Ref increments the reference count on the message.  Note that since the
 // underlying message is actually shared, consumers must take care not
 // to modify the message.  Applications should *NOT* make use of this
 // function -- it is intended for Protocol, Transport and internal use only.
 func (m *Message) Ref() *Message {
-	m.wg.Add(1)
+	m.debugRef()
+	atomic.AddInt32(&m.refcnt, 1)
 	return m
 }
 
-// Wait for the message to be delivered.  It MUST be called by the Send function.
+// Share returns a handle on the message for one of several recipients.  It is
+// intended for fan-out protocols.  Ordinarily, Share is equivalent to Ref and
+// all recipients share the same value.  If the sending portal has enabled
+// Cfg.CopyOnSend, each call returns a new message holding a deep copy of the
+// value.  Freeing the copy releases the original.
+func (m *Message) Share() *Message {
+	if m.clone == nil {
+		return m.Ref()
+	}
+
+	cp := m.dup()
+	cp.Value = m.clone(m.Value)
+	cp.receipt = m.receipt
+
+	cp.orig = m.Ref()
+	cp.done = unshare
+
+	return cp
+}
+
+// dup returns a new message with the same value and metadata.  The header is
+// copied, so the new message can be annotated without affecting the original.
+func (m *Message) dup() *Message {
+	cp := NewMsg()
+	cp.From = m.From
+	cp.Priority = m.Priority
+	cp.Deadline = m.Deadline
+	for k, v := range m.Header {
+		cp.Annotate(k, v)
+	}
+	cp.Value = m.Value
+	return cp
+}
+
+// own returns a handle on the message that the caller may modify.  If the
+// message is shared with other recipients, the caller's reference is exchanged
+// for a private copy, which releases the original when it is freed.
+func (m *Message) own() *Message {
+	if atomic.LoadInt32(&m.refcnt) == 1 {
+		return m
+	}
+
+	cp := m.dup()
+	cp.receipt = m.receipt
+	cp.seq = m.seq
+
+	cp.orig = m
+	cp.done = unshare
+
+	return cp
+}
+
+// delivered records that the message was received by the portal with the
+// specified ID.
+func (m *Message) delivered(id ID) {
+	if m.receipt != nil {
+		m.receipt.delivered(id)
+	}
+}
+
+// dropped records that the message was not delivered
+func (m *Message) dropped(reason error) {
+	if m.receipt != nil {
+		m.receipt.dropped(reason)
+	}
+}
+
+// Wait for the message to be delivered, then return it to the pool.  Unless the
+// message is detached, wait MUST be called by the Send function.
 func (m *Message) wait() {
-	m.wg.Wait()
-	msgPool.Put(m.Ref())
+	<-m.sig
+	msgPool.Put(m)
 }
 
+// detach the message from its sender, who will not call wait.  The message
+// returns to the pool as soon as it is freed.
+func (m *Message) detach() { m.done = recycle }
+
 // NewMsg returns a message with a single refcount
-func NewMsg() *Message { return msgPool.Get() }
+func NewMsg() *Message {
+	m := msgPool.Get()
+	m.refcnt = 1
+	m.done = notify
+	m.debugNew()
+	return m
+}
+
+// NewDetachedMsg returns a message with a single refcount, which nobody waits
+// on.  It returns to the pool as soon as it is freed.  Protocols use it for
+// messages they originate, e.g. to replay a value.
+func NewDetachedMsg() *Message {
+	m := NewMsg()
+	m.detach()
+	return m
+}
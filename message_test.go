@@ -28,3 +28,22 @@ func TestMessagePool(t *testing.T) {
 	}
 
 }
+
+func TestMessageExpired(t *testing.T) {
+	m := NewMsg()
+	defer m.Free()
+
+	if m.Expired() {
+		t.Error("message without deadline reported as expired")
+	}
+
+	m.Deadline = time.Now().Add(time.Hour)
+	if m.Expired() {
+		t.Error("message expired before its deadline")
+	}
+
+	m.Deadline = time.Now().Add(-time.Second)
+	if !m.Expired() {
+		t.Error("message did not expire after its deadline")
+	}
+}
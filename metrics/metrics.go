@@ -0,0 +1,107 @@
+// Package metrics exposes portal statistics to Prometheus
+package metrics
+
+import (
+	"github.com/lthibault/portal"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "portal"
+
+// Reporter is satisfied by all portals
+type Reporter interface {
+	Stats() portal.Stats
+}
+
+func desc(ptl, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", name),
+		help,
+		nil,
+		prometheus.Labels{"portal": ptl},
+	)
+}
+
+// Collector implements prometheus.Collector for a single portal.  Several
+// collectors may be registered with the same registry, provided they are
+// given distinct names.
+type Collector struct {
+	r Reporter
+
+	sent, recv, drop     *prometheus.Desc
+	expired, overflow    *prometheus.Desc
+	bytesSent, bytesRecv *prometheus.Desc
+	sendQ, recvQ, peers  *prometheus.Desc
+	sendLat, recvLat     *prometheus.Desc
+}
+
+// NewCollector returns a prometheus.Collector that scrapes the portal's Stats.
+// The name is used as the value of the "portal" label.
+func NewCollector(name string, r Reporter) *Collector {
+	return &Collector{
+		r:         r,
+		sent:      desc(name, "messages_sent_total", "Number of messages sent"),
+		recv:      desc(name, "messages_received_total", "Number of messages received"),
+		drop:      desc(name, "messages_dropped_total", "Number of messages dropped"),
+		expired:   desc(name, "messages_expired_total", "Number of messages dropped because they expired"),
+		overflow:  desc(name, "dead_letters_discarded_total", "Number of dead letters discarded because the dead-letter portal was full"),
+		bytesSent: desc(name, "bytes_sent_total", "Number of bytes sent in []byte payloads"),
+		bytesRecv: desc(name, "bytes_received_total", "Number of bytes received in []byte payloads"),
+		sendQ:     desc(name, "send_queue_depth", "Number of messages waiting to be sent"),
+		recvQ:     desc(name, "recv_queue_depth", "Number of messages waiting to be received"),
+		peers:     desc(name, "peers", "Number of connected peers"),
+		sendLat:   desc(name, "send_latency_seconds", "Time spent in calls to Send"),
+		recvLat:   desc(name, "recv_latency_seconds", "Time spent in calls to Recv"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sent
+	ch <- c.recv
+	ch <- c.drop
+	ch <- c.expired
+	ch <- c.overflow
+	ch <- c.bytesSent
+	ch <- c.bytesRecv
+	ch <- c.sendQ
+	ch <- c.recvQ
+	ch <- c.peers
+	ch <- c.sendLat
+	ch <- c.recvLat
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.r.Stats()
+
+	ch <- counter(c.sent, s.Sent)
+	ch <- counter(c.recv, s.Received)
+	ch <- counter(c.drop, s.Dropped)
+	ch <- counter(c.expired, s.Expired)
+	ch <- counter(c.overflow, s.DeadLetterOverflow)
+	ch <- counter(c.bytesSent, s.BytesSent)
+	ch <- counter(c.bytesRecv, s.BytesReceived)
+	ch <- gauge(c.sendQ, s.SendQueue)
+	ch <- gauge(c.recvQ, s.RecvQueue)
+	ch <- gauge(c.peers, s.Peers)
+	ch <- histogram(c.sendLat, s.SendLatency)
+	ch <- histogram(c.recvLat, s.RecvLatency)
+}
+
+func counter(d *prometheus.Desc, n uint64) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(n))
+}
+
+func gauge(d *prometheus.Desc, n int) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d, prometheus.GaugeValue, float64(n))
+}
+
+func histogram(d *prometheus.Desc, h portal.Histogram) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	for i, b := range h.Bounds {
+		buckets[b.Seconds()] = h.Counts[i]
+	}
+
+	return prometheus.MustNewConstHistogram(d, h.Count, h.Sum.Seconds(), buckets)
+}
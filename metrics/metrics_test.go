@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollector(t *testing.T) {
+	pushP := push.New(portal.Cfg{})
+	defer pushP.Close()
+
+	pullP := pull.New(portal.Cfg{})
+	defer pullP.Close()
+
+	if err := pushP.Bind("/test/metrics/collector"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pullP.Connect("/test/metrics/collector"); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector("push", pushP))
+	reg.MustRegister(NewCollector("pull", pullP))
+
+	go pushP.Send([]byte("hello"))
+	_ = pullP.Recv()
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := make(map[string]map[string]*dto.Metric)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			name := m.GetLabel()[0].GetValue()
+			if metrics[name] == nil {
+				metrics[name] = make(map[string]*dto.Metric)
+			}
+			metrics[name][mf.GetName()] = m
+		}
+	}
+
+	t.Run("Counters", func(t *testing.T) {
+		if n := metrics["pull"]["portal_messages_received_total"].GetCounter().GetValue(); n != 1 {
+			t.Errorf("expected 1 message received, got %v", n)
+		}
+
+		if n := metrics["pull"]["portal_bytes_received_total"].GetCounter().GetValue(); n != 5 {
+			t.Errorf("expected 5 bytes received, got %v", n)
+		}
+	})
+
+	t.Run("Gauges", func(t *testing.T) {
+		if n := metrics["push"]["portal_peers"].GetGauge().GetValue(); n != 1 {
+			t.Errorf("expected 1 peer, got %v", n)
+		}
+	})
+
+	t.Run("Histograms", func(t *testing.T) {
+		h := metrics["pull"]["portal_recv_latency_seconds"].GetHistogram()
+		if n := h.GetSampleCount(); n != 1 {
+			t.Errorf("expected 1 observation, got %d", n)
+		}
+
+		if n := len(h.GetBucket()); n != len(portal.LatencyBuckets) {
+			t.Errorf("expected %d buckets, got %d", len(portal.LatencyBuckets), n)
+		}
+	})
+}
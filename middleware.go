@@ -0,0 +1,66 @@
+package portal
+
+// Middleware intercepts messages as they travel through a portal.  Either
+// function may be nil, and either may drop the message by returning false.
+// Either may transform the message, or annotate it through its Header.  A
+// message that fan-out protocols (e.g. PUB, BUS and STAR) deliver to several
+// recipients is copied before Recv middleware runs, so that changes only affect
+// the receiving portal.
+//
+// Middleware is applied in the order in which it appears in Cfg.Middleware.
+// On the send path, middleware runs before the protocol's SendHook.  On the
+// receive path, it runs after the protocol's RecvHook.
+type Middleware struct {
+	Send func(*Message) bool
+	Recv func(*Message) bool
+}
+
+type hookChain []func(*Message) bool
+
+func (c hookChain) SendHook(msg *Message) bool { return c.apply(msg) }
+func (c hookChain) RecvHook(msg *Message) bool { return c.apply(msg) }
+
+func (c hookChain) apply(msg *Message) bool {
+	for _, hook := range c {
+		if !hook(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func mkSendChain(p Protocol, mw []Middleware) ProtocolSendHook {
+	var c hookChain
+	for _, m := range mw {
+		if m.Send != nil {
+			c = append(c, m.Send)
+		}
+	}
+
+	if h, ok := p.(ProtocolSendHook); ok {
+		c = append(c, h.SendHook)
+	}
+
+	if len(c) == 0 {
+		return nil
+	}
+	return c
+}
+
+func mkRecvChain(p Protocol, mw []Middleware) ProtocolRecvHook {
+	var c hookChain
+	if h, ok := p.(ProtocolRecvHook); ok {
+		c = append(c, h.RecvHook)
+	}
+
+	for _, m := range mw {
+		if m.Recv != nil {
+			c = append(c, m.Recv)
+		}
+	}
+
+	if len(c) == 0 {
+		return nil
+	}
+	return c
+}
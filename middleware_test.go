@@ -0,0 +1,161 @@
+package portal
+
+import (
+	"testing"
+
+	"github.com/SentimensRG/ctx"
+)
+
+func TestMiddleware(t *testing.T) {
+	var order []string
+	trace := func(name string, ok bool) func(*Message) bool {
+		return func(*Message) bool {
+			order = append(order, name)
+			return ok
+		}
+	}
+
+	mkPortal := func(p Protocol, mw ...Middleware) *portal {
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		return newPortal(p, Cfg{Doner: d, Size: 1, Middleware: mw}, cancel)
+	}
+
+	p := mockProtoExt{onSend: trace("proto", true), onRecv: trace("proto", true)}
+
+	t.Run("SendOrder", func(t *testing.T) {
+		order = nil
+
+		ptl := mkPortal(p, Middleware{Send: trace("0", true)}, Middleware{Send: trace("1", true)})
+		defer ptl.Close()
+
+		ptl.SendMsg(NewMsg())
+		(<-ptl.chSend).Free()
+
+		if len(order) != 3 || order[0] != "0" || order[1] != "1" || order[2] != "proto" {
+			t.Errorf("unexpected hook order %v", order)
+		}
+	})
+
+	t.Run("RecvOrder", func(t *testing.T) {
+		order = nil
+
+		ptl := mkPortal(p, Middleware{Recv: trace("0", true)}, Middleware{Recv: trace("1", true)})
+		defer ptl.Close()
+
+		ptl.chRecv <- NewMsg()
+		ptl.RecvMsg().Free()
+
+		if len(order) != 3 || order[0] != "proto" || order[1] != "0" || order[2] != "1" {
+			t.Errorf("unexpected hook order %v", order)
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		order = nil
+
+		ptl := mkPortal(p, Middleware{Send: trace("0", false)}, Middleware{Send: trace("1", true)})
+		defer ptl.Close()
+
+		ptl.SendMsg(NewMsg())
+
+		select {
+		case <-ptl.chSend:
+			t.Error("message SHOULD NOT have been sent")
+		default:
+		}
+
+		if len(order) != 1 {
+			t.Errorf("chain was not interrupted by drop (%v)", order)
+		}
+	})
+
+	t.Run("Transform", func(t *testing.T) {
+		ptl := mkPortal(mockProto{}, Middleware{
+			Send: func(msg *Message) bool {
+				msg.Annotate("test.original", msg.Value)
+				msg.Value = msg.Value.(int) * 2
+				return true
+			},
+		})
+		defer ptl.Close()
+
+		msg := NewMsg()
+		msg.Value = 21
+		ptl.SendMsg(msg)
+
+		m := <-ptl.chSend
+		if m.Value.(int) != 42 {
+			t.Errorf("expected 42, got %v", m.Value)
+		}
+
+		if m.Header["test.original"].(int) != 21 {
+			t.Errorf("expected annotation, got %v", m.Header)
+		}
+		m.Free()
+	})
+
+	t.Run("RecvTransform", func(t *testing.T) {
+		ptl := mkPortal(mockProto{}, Middleware{
+			Recv: func(msg *Message) bool {
+				msg.Annotate("test.original", msg.Value)
+				msg.Value = msg.Value.(int) * 2
+				return true
+			},
+		})
+		defer ptl.Close()
+
+		// shared with another recipient, as by a fan-out protocol
+		orig := NewDetachedMsg()
+		orig.Value = 21
+		ptl.chRecv <- orig.Ref()
+
+		m := ptl.RecvMsg()
+		if m.Value.(int) != 42 {
+			t.Errorf("expected 42, got %v", m.Value)
+		}
+
+		if m.Header["test.original"].(int) != 21 {
+			t.Errorf("expected annotation, got %v", m.Header)
+		}
+
+		if orig.Value.(int) != 21 || orig.Header != nil {
+			t.Error("shared message SHOULD NOT have been modified")
+		}
+
+		m.Free()
+		orig.Free()
+	})
+
+	t.Run("RecvDrop", func(t *testing.T) {
+		ptl := mkPortal(mockProto{}, Middleware{
+			Recv: func(msg *Message) bool { return msg.Value != "drop" },
+		})
+		defer ptl.Close()
+		ptl.setRunning()
+
+		go func() {
+			for _, v := range []string{"drop", "keep"} {
+				msg := NewDetachedMsg()
+				msg.Value = v
+				ptl.chRecv <- msg
+			}
+		}()
+
+		if v := ptl.Recv(); v != "keep" {
+			t.Errorf("expected keep, got %v", v)
+		}
+
+		if n := ptl.Stats().Dropped; n != 1 {
+			t.Errorf("expected 1 drop, got %d", n)
+		}
+	})
+
+	t.Run("NoHooks", func(t *testing.T) {
+		ptl := mkPortal(mockProto{})
+		defer ptl.Close()
+
+		if ptl.ProtocolSendHook != nil || ptl.ProtocolRecvHook != nil {
+			t.Error("hooks should be nil when neither protocol nor middleware provide any")
+		}
+	})
+}
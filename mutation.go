@@ -0,0 +1,158 @@
+package portal
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// mutationWindow is the number of recently-sent values that are checked for
+// mutation by portals that have Cfg.DetectMutation set.
+const mutationWindow = 64
+
+type snapshot struct {
+	v, cp interface{}
+}
+
+// mutationDetector keeps deep copies of recently-sent values, and reports
+// values that no longer match their copy.
+type mutationDetector struct {
+	sync.Mutex
+	ring []snapshot
+	i    int
+}
+
+func newMutationDetector() *mutationDetector {
+	return &mutationDetector{ring: make([]snapshot, mutationWindow)}
+}
+
+func (d *mutationDetector) Track(v interface{}) {
+	d.Lock()
+	d.ring[d.i] = snapshot{v: v, cp: clone(v)}
+	d.i = (d.i + 1) % len(d.ring)
+	d.Unlock()
+}
+
+func (d *mutationDetector) Check() error {
+	d.Lock()
+	defer d.Unlock()
+
+	for i, s := range d.ring {
+		if s.v != nil && !unchanged(s.v, s.cp) {
+			d.ring[i] = snapshot{} // report once
+			return errors.Errorf("value of type %T was modified after it was sent", s.v)
+		}
+	}
+
+	return nil
+}
+
+// unchanged returns true if v still matches cp, a copy made by clone.  Unlike
+// reflect.DeepEqual, it considers NaNs equal to themselves, and functions and
+// channels equal if they are the same, since clone does not copy them.
+func unchanged(v, cp interface{}) bool {
+	c := comparer{seen: make(map[[2]uintptr]bool)}
+	return c.equal(reflect.ValueOf(v), reflect.ValueOf(cp))
+}
+
+type comparer struct {
+	seen map[[2]uintptr]bool // guards against cyclic pointers
+}
+
+func (c comparer) equal(v, cp reflect.Value) bool {
+	if !v.IsValid() || !cp.IsValid() {
+		return v.IsValid() == cp.IsValid()
+	}
+
+	if v.Type() != cp.Type() {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || cp.IsNil() {
+			return v.IsNil() == cp.IsNil()
+		}
+
+		key := [2]uintptr{v.Pointer(), cp.Pointer()}
+		if c.seen[key] {
+			return true
+		}
+
+		c.seen[key] = true
+		return c.equal(v.Elem(), cp.Elem())
+
+	case reflect.Interface:
+		if v.IsNil() || cp.IsNil() {
+			return v.IsNil() == cp.IsNil()
+		}
+		return c.equal(v.Elem(), cp.Elem())
+
+	case reflect.Slice:
+		if v.IsNil() != cp.IsNil() || v.Len() != cp.Len() {
+			return false
+		}
+		return c.elems(v, cp)
+
+	case reflect.Array:
+		return c.elems(v, cp)
+
+	case reflect.Map:
+		if v.IsNil() != cp.IsNil() || v.Len() != cp.Len() {
+			return false
+		}
+
+		for iter := v.MapRange(); iter.Next(); {
+			e := cp.MapIndex(iter.Key())
+			if !e.IsValid() || !c.equal(iter.Value(), e) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !c.equal(v.Field(i), cp.Field(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Float32, reflect.Float64:
+		return sameFloat(v.Float(), cp.Float())
+
+	case reflect.Complex64, reflect.Complex128:
+		x, y := v.Complex(), cp.Complex()
+		return sameFloat(real(x), real(y)) && sameFloat(imag(x), imag(y))
+
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return v.Pointer() == cp.Pointer()
+
+	case reflect.Bool:
+		return v.Bool() == cp.Bool()
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == cp.Int()
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == cp.Uint()
+
+	case reflect.String:
+		return v.String() == cp.String()
+
+	default:
+		return true
+	}
+}
+
+func (c comparer) elems(v, cp reflect.Value) bool {
+	for i := 0; i < v.Len(); i++ {
+		if !c.equal(v.Index(i), cp.Index(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameFloat(x, y float64) bool { return x == y || (x != x && y != y) }
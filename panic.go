@@ -0,0 +1,35 @@
+package portal
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// PanicError is reported to Cfg.OnError when a protocol goroutine serving a
+// peer panics.  The peer is disconnected.
+type PanicError struct {
+	Peer  ID
+	Value interface{} // passed to panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic serving peer %s: %v", e.Peer, e.Value)
+}
+
+func (p *portal) Recover(ep Endpoint) {
+	if r := recover(); r != nil {
+		p.fail(&PanicError{Peer: ep.ID(), Value: r, Stack: debug.Stack()})
+		p.disconnect(ep.ID())
+	}
+}
+
+// fail reports an error that occurred in the background
+func (p *portal) fail(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	} else {
+		log.Printf("portal: %s", err)
+	}
+}
@@ -0,0 +1,99 @@
+package portal
+
+import (
+	"testing"
+	"time"
+)
+
+// panicProto panics while serving each peer
+type panicProto struct {
+	mockProtoSig
+	ptl     ProtocolPortal
+	removed chan Endpoint
+	trigger chan struct{} // if set, panic once it is closed
+}
+
+func (p *panicProto) Init(ptl ProtocolPortal) { p.ptl = ptl }
+
+func (p *panicProto) AddEndpoint(ep Endpoint) {
+	go func() {
+		defer p.ptl.Recover(ep)
+		if p.trigger != nil {
+			<-p.trigger
+		}
+		panic("boom")
+	}()
+}
+
+func (p *panicProto) RemoveEndpoint(ep Endpoint) { p.removed <- ep }
+
+func TestRecover(t *testing.T) {
+	errs := make(chan error, 1)
+	proto := &panicProto{removed: make(chan Endpoint, 1)}
+
+	ptl := MakePortal(Cfg{OnError: func(err error) { errs <- err }}, proto).(*portal)
+	defer ptl.Close()
+
+	ep := mockEP{id: NewID()}
+	ptl.ConnectEndpoint(ep)
+
+	select {
+	case err := <-errs:
+		if perr, ok := err.(*PanicError); !ok {
+			t.Errorf("expected *PanicError, got %T", err)
+		} else if perr.Peer != ep.ID() || perr.Value != "boom" {
+			t.Errorf("unexpected error %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+
+	select {
+	case removed := <-proto.removed:
+		if removed.ID() != ep.ID() {
+			t.Error("wrong endpoint removed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("endpoint not removed")
+	}
+
+	select {
+	case <-ptl.Done():
+		t.Error("portal closed")
+	default:
+	}
+}
+
+func TestRecoverRemote(t *testing.T) {
+	proto := &panicProto{removed: make(chan Endpoint, 1), trigger: make(chan struct{})}
+	ptl := MakePortal(Cfg{OnError: func(error) {}}, proto).(*portal)
+	defer ptl.Close()
+
+	if err := ptl.Bind("/test/panic/remote"); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := mockProto{epAdded: make(chan Endpoint, 1), epRemoved: make(chan Endpoint, 1)}
+	remote := MakePortal(Cfg{}, peer).(*portal)
+	defer remote.Close()
+
+	if err := remote.Connect("/test/panic/remote"); err != nil {
+		t.Fatal(err)
+	}
+	close(proto.trigger) // both ends are connected
+
+	select {
+	case removed := <-peer.epRemoved:
+		if removed.ID() != ptl.ID() {
+			t.Error("wrong endpoint removed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("remote end of the connection was not removed")
+	}
+
+	select {
+	case <-remote.Done():
+		t.Error("remote portal closed")
+	default:
+	}
+}
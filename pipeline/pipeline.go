@@ -0,0 +1,203 @@
+// Package pipeline composes PUSH/PULL portals into processing stages.
+//
+// Each stage connects a PULL portal to its input addresses, and binds a PUSH
+// portal to each of its output addresses.  Stages must therefore be created in
+// the order in which values flow, starting from the portal bound by the source.
+//
+// A stage runs until it is closed, or until the Doner in its portal.Cfg
+// expires.  Passing the Doner of an upstream stage causes a pipeline to shut
+// down from the source onwards.
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/SentimensRG/ctx/sigctx"
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+	"github.com/pkg/errors"
+)
+
+// Option configures a stage
+type Option func(*Stage)
+
+// Workers sets the number of goroutines that process values concurrently.  It
+// defaults to 1.
+func Workers(n int) Option {
+	return func(s *Stage) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+// Ordered causes a stage with several workers to emit its output in the order
+// in which the input was received.
+func Ordered() Option { return func(s *Stage) { s.ordered = true } }
+
+// Stage is a running step of a pipeline
+type Stage struct {
+	ctx.Doner
+	cancel func()
+
+	workers int
+	ordered bool
+
+	in  portal.ReadOnly
+	out []chan<- interface{}
+}
+
+func newStage(cfg portal.Cfg, in, out []string, opt []Option) (*Stage, error) {
+	if cfg.Doner == nil {
+		cfg.Doner = sigctx.New()
+	}
+
+	s := &Stage{workers: 1}
+	cfg.Doner, s.cancel = ctx.WithCancel(cfg.Doner)
+	s.Doner = cfg.Doner
+
+	for _, o := range opt {
+		o(s)
+	}
+
+	s.in = pull.New(cfg)
+	for _, addr := range in {
+		if err := s.in.Connect(addr); err != nil {
+			s.Close()
+			return nil, errors.Wrap(err, "connect")
+		}
+	}
+
+	s.out = make([]chan<- interface{}, len(out))
+	for i, addr := range out {
+		p := push.New(cfg)
+		if err := p.Bind(addr); err != nil {
+			p.Close()
+			s.Close()
+			return nil, errors.Wrap(err, "bind")
+		}
+
+		s.out[i] = portal.SendChan(p)
+	}
+
+	return s, nil
+}
+
+// Close the stage and its portals
+func (s *Stage) Close() { s.cancel() }
+
+// stop the stage once its input is closed
+func (s *Stage) stop() {
+	for _, ch := range s.out {
+		close(ch) // closes the output portal
+	}
+
+	s.in.Close()
+	s.cancel()
+}
+
+// emitFunc sends a value to the i-th output
+type emitFunc func(i int, v interface{})
+
+// processFunc handles a single input value, emitting zero or more outputs
+type processFunc func(v interface{}, emit emitFunc)
+
+func (s *Stage) emit(i int, v interface{}) { s.out[i] <- v }
+
+// run the process function on each input value, using the configured number of
+// workers
+func (s *Stage) run(f processFunc) {
+	if s.ordered && s.workers > 1 {
+		s.runOrdered(f)
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for v := range s.in.All(nil) {
+				f(v, s.emit)
+			}
+		}()
+	}
+
+	wg.Wait()
+	s.stop()
+}
+
+type job struct {
+	seq uint64
+	v   interface{}
+}
+
+type output struct {
+	i int
+	v interface{}
+}
+
+type result struct {
+	seq  uint64
+	outs []output
+}
+
+// runOrdered is like run, but emits the results in input order.  The number of
+// values in flight is bounded, so a slow worker stalls the stage rather than
+// causing results to pile up.
+func (s *Stage) runOrdered(f processFunc) {
+	window := make(chan struct{}, 2*s.workers)
+	jobs := make(chan job)
+	results := make(chan result)
+
+	go func() {
+		defer close(jobs)
+
+		var seq uint64
+		for v := range s.in.All(nil) {
+			window <- struct{}{}
+			jobs <- job{seq: seq, v: v}
+			seq++
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				r := result{seq: j.seq}
+				f(j.v, func(i int, v interface{}) { r.outs = append(r.outs, output{i, v}) })
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var next uint64
+	pending := make(map[uint64][]output)
+	for r := range results {
+		pending[r.seq] = r.outs
+
+		for outs, ok := pending[next]; ok; outs, ok = pending[next] {
+			delete(pending, next)
+			for _, o := range outs {
+				s.emit(o.i, o.v)
+			}
+
+			next++
+			<-window
+		}
+	}
+
+	s.stop()
+}
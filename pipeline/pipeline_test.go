@@ -0,0 +1,231 @@
+package pipeline
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+)
+
+func source(t *testing.T, addr string, vs ...interface{}) portal.WriteOnly {
+	p := push.New(portal.Cfg{})
+	if err := p.Bind(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for _, v := range vs {
+			p.Send(v)
+		}
+	}()
+
+	return p
+}
+
+func sink(t *testing.T, addr string) portal.ReadOnly {
+	p := pull.New(portal.Cfg{})
+	if err := p.Connect(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func recv(t *testing.T, p portal.ReadOnly, n int) []interface{} {
+	ch := make(chan interface{})
+	go func() {
+		for i := 0; i < n; i++ {
+			ch <- p.Recv()
+		}
+	}()
+
+	vs := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case v := <-ch:
+			vs = append(vs, v)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d values", i, n)
+		}
+	}
+
+	return vs
+}
+
+func must(t *testing.T) func(*Stage, error) *Stage {
+	return func(s *Stage, err error) *Stage {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+}
+
+func TestStages(t *testing.T) {
+	double := func(v interface{}) interface{} { return v.(int) * 2 }
+	even := func(v interface{}) bool { return v.(int)%2 == 0 }
+	twice := func(v interface{}) []interface{} { return []interface{}{v, v} }
+
+	t.Run("Map", func(t *testing.T) {
+		defer source(t, "/test/pipeline/map/in", 1, 2, 3).Close()
+		defer must(t)(Map(portal.Cfg{}, "/test/pipeline/map/in", "/test/pipeline/map/out", double)).Close()
+		out := sink(t, "/test/pipeline/map/out")
+		defer out.Close()
+
+		if vs := recv(t, out, 3); vs[0] != 2 || vs[1] != 4 || vs[2] != 6 {
+			t.Errorf("unexpected values %v", vs)
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		defer source(t, "/test/pipeline/filter/in", 1, 2, 3, 4).Close()
+		defer must(t)(Filter(portal.Cfg{}, "/test/pipeline/filter/in", "/test/pipeline/filter/out", even)).Close()
+		out := sink(t, "/test/pipeline/filter/out")
+		defer out.Close()
+
+		if vs := recv(t, out, 2); vs[0] != 2 || vs[1] != 4 {
+			t.Errorf("unexpected values %v", vs)
+		}
+	})
+
+	t.Run("FlatMap", func(t *testing.T) {
+		defer source(t, "/test/pipeline/flatmap/in", 1, 2).Close()
+		defer must(t)(FlatMap(portal.Cfg{}, "/test/pipeline/flatmap/in", "/test/pipeline/flatmap/out", twice)).Close()
+		out := sink(t, "/test/pipeline/flatmap/out")
+		defer out.Close()
+
+		if vs := recv(t, out, 4); vs[0] != 1 || vs[1] != 1 || vs[2] != 2 || vs[3] != 2 {
+			t.Errorf("unexpected values %v", vs)
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		defer source(t, "/test/pipeline/merge/in/0", 1).Close()
+		defer source(t, "/test/pipeline/merge/in/1", 2).Close()
+		defer must(t)(Merge(portal.Cfg{}, []string{"/test/pipeline/merge/in/0", "/test/pipeline/merge/in/1"}, "/test/pipeline/merge/out")).Close()
+		out := sink(t, "/test/pipeline/merge/out")
+		defer out.Close()
+
+		if vs := recv(t, out, 2); vs[0].(int)+vs[1].(int) != 3 {
+			t.Errorf("unexpected values %v", vs)
+		}
+	})
+
+	t.Run("Split", func(t *testing.T) {
+		route := func(v interface{}) int { return v.(int) % 2 }
+
+		defer source(t, "/test/pipeline/split/in", 1, 2, 3, 4).Close()
+		defer must(t)(Split(portal.Cfg{}, "/test/pipeline/split/in", []string{"/test/pipeline/split/even", "/test/pipeline/split/odd"}, route)).Close()
+		evens := sink(t, "/test/pipeline/split/even")
+		defer evens.Close()
+		odds := sink(t, "/test/pipeline/split/odd")
+		defer odds.Close()
+
+		done := make(chan []interface{})
+		go func() { done <- recv(t, odds, 2) }()
+
+		if vs := recv(t, evens, 2); vs[0] != 2 || vs[1] != 4 {
+			t.Errorf("unexpected even values %v", vs)
+		}
+
+		if vs := <-done; vs[0] != 1 || vs[1] != 3 {
+			t.Errorf("unexpected odd values %v", vs)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		defer source(t, "/test/pipeline/batch/in", 1, 2, 3).Close()
+		defer must(t)(Batch(portal.Cfg{}, "/test/pipeline/batch/in", "/test/pipeline/batch/out", 2, time.Millisecond*10)).Close()
+		out := sink(t, "/test/pipeline/batch/out")
+		defer out.Close()
+
+		vs := recv(t, out, 2)
+		if b := vs[0].([]interface{}); len(b) != 2 || b[0] != 1 || b[1] != 2 {
+			t.Errorf("unexpected batch %v", b)
+		}
+
+		if b := vs[1].([]interface{}); len(b) != 1 || b[0] != 3 {
+			t.Errorf("expected incomplete batch, got %v", b)
+		}
+	})
+
+	t.Run("BatchClose", func(t *testing.T) {
+		rq := make(chan interface{})
+		out := make(chan interface{}, 1)
+		s := &Stage{cancel: func() {}, in: pull.New(portal.Cfg{}), out: []chan<- interface{}{out}}
+
+		go func() {
+			rq <- 1
+			close(rq)
+		}()
+
+		s.batch(rq, 2, 0)
+
+		if b, ok := (<-out).([]interface{}); !ok || len(b) != 1 || b[0] != 1 {
+			t.Errorf("expected incomplete batch, got %v", b)
+		}
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		defer source(t, "/test/pipeline/window/in", 1, 2, 3, 4, 5).Close()
+		defer must(t)(Window(portal.Cfg{}, "/test/pipeline/window/in", "/test/pipeline/window/out", 3, 1)).Close()
+		out := sink(t, "/test/pipeline/window/out")
+		defer out.Close()
+
+		vs := recv(t, out, 3)
+		for i, v := range vs {
+			if w := v.([]interface{}); len(w) != 3 || w[0] != i+1 || w[2] != i+3 {
+				t.Errorf("unexpected window %v", w)
+			}
+		}
+	})
+}
+
+func TestOrdered(t *testing.T) {
+	const n = 100
+
+	vs := make([]interface{}, n)
+	for i := range vs {
+		vs[i] = i
+	}
+
+	jitter := func(v interface{}) interface{} {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		return v
+	}
+
+	defer source(t, "/test/pipeline/ordered/in", vs...).Close()
+	defer must(t)(Map(portal.Cfg{}, "/test/pipeline/ordered/in", "/test/pipeline/ordered/out", jitter, Workers(4), Ordered())).Close()
+	out := sink(t, "/test/pipeline/ordered/out")
+	defer out.Close()
+
+	for i, v := range recv(t, out, n) {
+		if v != i {
+			t.Fatalf("expected %d, got %v", i, v)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	id := func(v interface{}) interface{} { return v }
+
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+
+	defer source(t, "/test/pipeline/shutdown/0").Close()
+	s0 := must(t)(Map(portal.Cfg{Doner: d}, "/test/pipeline/shutdown/0", "/test/pipeline/shutdown/1", id))
+	s1 := must(t)(Map(portal.Cfg{Doner: s0}, "/test/pipeline/shutdown/1", "/test/pipeline/shutdown/2", id, Workers(2)))
+
+	cancel()
+
+	for _, s := range []*Stage{s0, s1} {
+		select {
+		case <-s.Done():
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("stage not closed")
+		}
+	}
+}
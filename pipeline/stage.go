@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/lthibault/portal"
+)
+
+// Map applies f to each value
+func Map(cfg portal.Cfg, in, out string, f func(interface{}) interface{}, opt ...Option) (*Stage, error) {
+	s, err := newStage(cfg, []string{in}, []string{out}, opt)
+	if err == nil {
+		go s.run(func(v interface{}, emit emitFunc) { emit(0, f(v)) })
+	}
+
+	return s, err
+}
+
+// Filter discards values for which f returns false
+func Filter(cfg portal.Cfg, in, out string, f func(interface{}) bool, opt ...Option) (*Stage, error) {
+	s, err := newStage(cfg, []string{in}, []string{out}, opt)
+	if err == nil {
+		go s.run(func(v interface{}, emit emitFunc) {
+			if f(v) {
+				emit(0, v)
+			}
+		})
+	}
+
+	return s, err
+}
+
+// FlatMap applies f to each value, and emits each of the results
+func FlatMap(cfg portal.Cfg, in, out string, f func(interface{}) []interface{}, opt ...Option) (*Stage, error) {
+	s, err := newStage(cfg, []string{in}, []string{out}, opt)
+	if err == nil {
+		go s.run(func(v interface{}, emit emitFunc) {
+			for _, r := range f(v) {
+				emit(0, r)
+			}
+		})
+	}
+
+	return s, err
+}
+
+// Merge forwards the values from several inputs to a single output
+func Merge(cfg portal.Cfg, in []string, out string, opt ...Option) (*Stage, error) {
+	s, err := newStage(cfg, in, []string{out}, opt)
+	if err == nil {
+		go s.run(func(v interface{}, emit emitFunc) { emit(0, v) })
+	}
+
+	return s, err
+}
+
+// Split forwards each value to the output whose index is returned by route.
+// Values for which route returns an index out of range are discarded.
+func Split(cfg portal.Cfg, in string, out []string, route func(interface{}) int, opt ...Option) (*Stage, error) {
+	s, err := newStage(cfg, []string{in}, out, opt)
+	if err == nil {
+		go s.run(func(v interface{}, emit emitFunc) {
+			if i := route(v); i >= 0 && i < len(out) {
+				emit(i, v)
+			}
+		})
+	}
+
+	return s, err
+}
+
+// Batch groups values into slices of n, which are emitted as []interface{}.
+// If timeout is positive, an incomplete batch is emitted once its first value
+// is older than the timeout, and when the input is closed.
+func Batch(cfg portal.Cfg, in, out string, n int, timeout time.Duration) (*Stage, error) {
+	s, err := newStage(cfg, []string{in}, []string{out}, nil)
+	if err == nil {
+		go s.batch(portal.RecvChan(s.in), n, timeout)
+	}
+
+	return s, err
+}
+
+func (s *Stage) batch(rq <-chan interface{}, n int, timeout time.Duration) {
+	defer s.stop()
+
+	var (
+		b       []interface{}
+		t       *time.Timer
+		expired <-chan time.Time
+	)
+
+	for {
+		select {
+		case v, ok := <-rq:
+			if !ok {
+				if len(b) > 0 {
+					s.emit(0, b)
+				}
+				return
+			}
+
+			if b = append(b, v); len(b) == 1 && timeout > 0 {
+				t = time.NewTimer(timeout)
+				expired = t.C
+			}
+
+			if len(b) < n {
+				continue
+			}
+		case <-expired:
+		}
+
+		if t != nil {
+			t.Stop()
+			t, expired = nil, nil
+		}
+
+		s.emit(0, b)
+		b = nil
+	}
+}
+
+// Window emits the most recent size values, as a []interface{}, after every
+// step values.  Nothing is emitted until size values have been received.
+func Window(cfg portal.Cfg, in, out string, size, step int) (*Stage, error) {
+	s, err := newStage(cfg, []string{in}, []string{out}, nil)
+	if err == nil {
+		go s.window(size, step)
+	}
+
+	return s, err
+}
+
+func (s *Stage) window(size, step int) {
+	defer s.stop()
+
+	var n int
+	w := make([]interface{}, 0, size)
+	for v := range s.in.All(nil) {
+		if len(w) == size {
+			w = append(w[:0], w[1:]...)
+		}
+		w = append(w, v)
+
+		if n++; len(w) == size && n >= step {
+			s.emit(0, append([]interface{}(nil), w...))
+			n = 0
+		}
+	}
+}
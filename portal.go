@@ -1,6 +1,8 @@
 package portal
 
 import (
+	"iter"
+
 	"github.com/SentimensRG/ctx"
 	uuid "github.com/satori/go.uuid"
 )
@@ -18,18 +20,25 @@ type Transporter interface {
 	Connect(string) error
 	Bind(string) error
 	Close()
+	Stats() Stats
 }
 
 // ReadOnly is the portal equivalent of <-chan
 type ReadOnly interface {
 	Transporter
 	Recv() interface{}
+	RecvOK() (interface{}, bool)
+	RecvHeader() (interface{}, Header)
+	All(ctx.Doner) iter.Seq[interface{}]
 }
 
 // WriteOnly is the portal equivalent of chan<-
 type WriteOnly interface {
 	Transporter
 	Send(interface{})
+	SendPriority(interface{}, int)
+	SendHeader(interface{}, Header)
+	SendAsync(interface{}) Future
 }
 
 // Portal is the main access handle applications use to access the protocol
@@ -39,7 +48,13 @@ type WriteOnly interface {
 type Portal interface {
 	Transporter
 	Send(interface{})
+	SendPriority(interface{}, int)
+	SendHeader(interface{}, Header)
+	SendAsync(interface{}) Future
 	Recv() interface{}
+	RecvOK() (interface{}, bool)
+	RecvHeader() (interface{}, Header)
+	All(ctx.Doner) iter.Seq[interface{}]
 }
 
 // Endpoint is used by the Protocol implementation to access the underlying
@@ -51,6 +66,11 @@ type Endpoint interface {
 	SendChannel() <-chan *Message
 	RecvChannel() chan<- *Message
 	Signature() ProtocolSignature
+
+	// Redeliver hands back a message that the peer identified by from did not
+	// process (see ProtocolRedeliverer).  It returns false if the message was
+	// not accepted, in which case the caller retains ownership.
+	Redeliver(msg *Message, from ID) bool
 }
 
 // ProtocolSignature defines which protocols can talk to each other
@@ -113,6 +133,19 @@ type ProtocolPortal interface {
 	// and the protocol should stop any further read operations on this
 	// instance.
 	CloseChannel() <-chan struct{}
+
+	// Drop is called when the protocol discards a message instead of
+	// delivering it, e.g. because it has expired.  The portal takes ownership
+	// of the message and records the reason.
+	Drop(*Message, error)
+
+	// ID of the portal
+	ID() ID
+
+	// Recover must be deferred by goroutines that serve a peer.  If the
+	// goroutine panics, the panic is reported to Cfg.OnError and the peer is
+	// disconnected, but the portal keeps running.
+	Recover(Endpoint)
 }
 
 // ProtocolSendHook allows protocol implementers to extend existing protocols
@@ -127,7 +160,37 @@ type ProtocolSendHook interface {
 // ProtocolRecvHook allows protocol implementers to extend existing protocols
 type ProtocolRecvHook interface {
 	// RecvHook is called just before the message is handed to the
-	// application.  The message may be modified.  If false is returned,
-	// then the message is dropped.
+	// application, and may modify it:  a message shared with other
+	// recipients is copied first.  If false is returned, then the message is
+	// dropped.
 	RecvHook(*Message) bool
 }
+
+// ProtocolRecvFilter allows protocols to discard some of the values they
+// receive, e.g. to implement subscriptions
+type ProtocolRecvFilter interface {
+	// Filter is called with each decoded value before it is handed to the
+	// application.  If false is returned, the value is discarded silently:  it
+	// is neither delivered nor counted as dropped.
+	Filter(interface{}) bool
+}
+
+// ProtocolSendQueue allows protocols to control the messages that peers read
+// from the portal, e.g. to dispatch every message themselves.
+type ProtocolSendQueue interface {
+	// SendQueue is called once the protocol has been initialized.  If it
+	// returns a channel, peers read messages from it rather than from the
+	// portal, and the protocol is responsible for consuming the channel
+	// returned by ProtocolPortal.SendChannel during Init.
+	SendQueue() <-chan *Message
+}
+
+// ProtocolRecvQueue allows protocols to process incoming messages before they
+// are queued for the application, e.g. to reorder them.
+type ProtocolRecvQueue interface {
+	// RecvQueue is called once the protocol has been initialized.  If it
+	// returns a channel, peers deliver messages to it rather than to the
+	// portal, and the protocol is responsible for forwarding them to the
+	// channel returned by ProtocolPortal.RecvChannel during Init.
+	RecvQueue() chan<- *Message
+}
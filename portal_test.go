@@ -27,11 +27,13 @@ type mockEP struct {
 	sig mockProtoSig
 }
 
+func (m mockEP) Done() <-chan struct{}        { return nil }
 func (m mockEP) ID() ID                       { return m.id }
 func (m mockEP) Close()                       {}
 func (m mockEP) RecvChannel() chan<- *Message { return m.rc }
 func (m mockEP) SendChannel() <-chan *Message { return m.sc }
 func (m mockEP) Signature() ProtocolSignature { return m.sig }
+func (m mockEP) Redeliver(*Message, ID) bool  { return false }
 
 type mockProtoExt struct {
 	mockProto
@@ -0,0 +1,108 @@
+package portal
+
+import (
+	"container/heap"
+	"sync/atomic"
+
+	"github.com/SentimensRG/ctx"
+)
+
+type prioItem struct {
+	msg *Message
+	seq uint64 // preserves FIFO order among messages of equal priority
+}
+
+type msgHeap []prioItem
+
+func (h msgHeap) Len() int { return len(h) }
+func (h msgHeap) Less(i, j int) bool {
+	if h[i].msg.Priority == h[j].msg.Priority {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].msg.Priority > h[j].msg.Priority
+}
+func (h msgHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *msgHeap) Push(x interface{}) { *h = append(*h, x.(prioItem)) }
+func (h *msgHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = prioItem{}
+	*h = old[:len(old)-1]
+	return item
+}
+
+// prioQueue is a bounded buffer that releases messages in order of priority.
+// Messages written to in are read from out, highest priority first.
+type prioQueue struct {
+	in, out chan *Message
+	n       int64
+	cap     int
+	seq     uint64
+	h       msgHeap
+	drop    func(*Message, error) // releases messages left over on close
+}
+
+func newPrioQueue(d ctx.Doner, size int, drop func(*Message, error)) *prioQueue {
+	if size < 1 {
+		size = 1
+	}
+
+	q := &prioQueue{
+		in:   make(chan *Message),
+		out:  make(chan *Message),
+		cap:  size,
+		h:    make(msgHeap, 0, size),
+		drop: drop,
+	}
+
+	go q.run(d)
+	return q
+}
+
+func (q *prioQueue) Len() int { return int(atomic.LoadInt64(&q.n)) }
+
+func (q *prioQueue) run(d ctx.Doner) {
+	defer q.drain()
+
+	src := q.in
+	for {
+		var in chan *Message
+		if len(q.h) < q.cap {
+			in = src
+		}
+
+		var out chan *Message
+		var next *Message
+		if len(q.h) > 0 {
+			out = q.out
+			next = q.h[0].msg
+		}
+
+		select {
+		case <-d.Done():
+			return
+		case msg, ok := <-in:
+			if !ok { // protocol closed the channel; e.g. PUSH never receives
+				src = nil
+				continue
+			}
+
+			q.seq++
+			heap.Push(&q.h, prioItem{msg: msg, seq: q.seq})
+			atomic.AddInt64(&q.n, 1)
+		case out <- next:
+			heap.Pop(&q.h)
+			atomic.AddInt64(&q.n, -1)
+		}
+	}
+}
+
+func (q *prioQueue) drain() {
+	for _, item := range q.h {
+		if item.msg != nil {
+			q.drop(item.msg, ErrClosed)
+		}
+	}
+	q.h = nil
+	atomic.StoreInt64(&q.n, 0)
+}
@@ -0,0 +1,115 @@
+package portal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+)
+
+// waitLen polls the queue until it reaches the expected length, since the
+// pump goroutine updates the count asynchronously.
+func waitLen(q *prioQueue, n int) int {
+	deadline := time.Now().Add(time.Millisecond * 100)
+	for q.Len() != n && time.Now().Before(deadline) {
+		time.Sleep(time.Microsecond * 10)
+	}
+	return q.Len()
+}
+
+func TestPrioQueue(t *testing.T) {
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	defer cancel()
+
+	q := newPrioQueue(d, 4, func(msg *Message, _ error) { msg.Free() })
+
+	for i, prio := range []int{0, 2, 1, 2} {
+		msg := NewMsg()
+		msg.Value = i
+		msg.Priority = prio
+		q.in <- msg
+	}
+
+	if n := waitLen(q, 4); n != 4 {
+		t.Errorf("expected 4 queued messages, got %d", n)
+	}
+
+	for _, expected := range []int{1, 3, 2, 0} {
+		select {
+		case msg := <-q.out:
+			if msg.Value.(int) != expected {
+				t.Errorf("expected message %d, got %d", expected, msg.Value)
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("queue did not release message")
+		}
+	}
+
+	t.Run("Full", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			q.in <- NewMsg()
+		}
+
+		select {
+		case q.in <- NewMsg():
+			t.Error("queue accepted message beyond capacity")
+		case <-time.After(time.Millisecond):
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		defer cancel()
+
+		q := newPrioQueue(d, 1, nil)
+		close(q.in)
+
+		select {
+		case msg := <-q.out:
+			t.Errorf("closed queue released message %v", msg)
+		case <-time.After(time.Millisecond):
+		}
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+
+		dropped := make(chan error, 1)
+		q := newPrioQueue(d, 1, func(msg *Message, reason error) {
+			msg.Free()
+			dropped <- reason
+		})
+
+		q.in <- NewMsg()
+		cancel()
+
+		select {
+		case err := <-dropped:
+			if err != ErrClosed {
+				t.Errorf("expected ErrClosed, got %v", err)
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Error("queued message was not dropped")
+		}
+	})
+}
+
+func TestSendPriority(t *testing.T) {
+	d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+	ptl := newPortal(mockProto{}, Cfg{Doner: d, Size: 8, PriorityQueue: true}, cancel)
+	defer ptl.Close()
+
+	ptl.setRunning()
+
+	ptl.Send("bulk")
+	ptl.Send("bulk")
+	ptl.SendPriority("shutdown", 10)
+
+	if n := waitLen(ptl.sendPQ, 3); n != 3 {
+		t.Errorf("expected send queue depth of 3, got %d", n)
+	}
+
+	if msg := <-ptl.SendChannel(); msg.Value.(string) != "shutdown" {
+		t.Errorf("control message did not overtake bulk data (got %v)", msg.Value)
+	}
+}
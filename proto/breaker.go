@@ -0,0 +1,205 @@
+package proto
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lthibault/portal"
+)
+
+// BreakerState is the state of a peer's circuit breaker
+type BreakerState uint8
+
+const (
+	// BreakerClosed admits messages to the peer
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen excludes the peer until the cooldown has elapsed
+	BreakerOpen
+
+	// BreakerHalfOpen admits a single probe.  If it succeeds, the peer is
+	// readmitted;  otherwise, the breaker opens again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerCfg configures circuit breakers
+type BreakerCfg struct {
+	// Failures is the number of consecutive failures that trips a breaker.
+	// It defaults to 1.
+	Failures int
+
+	// Cooldown is the time an open breaker waits before admitting a probe
+	Cooldown time.Duration
+}
+
+// PeerState describes a peer's circuit breaker
+type PeerState struct {
+	ID       portal.ID
+	State    BreakerState
+	Failures int       // consecutive failures
+	Since    time.Time // time of the last state change
+}
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	since    time.Time
+}
+
+// Breakers maintains a circuit breaker for each peer of a portal.  Protocols
+// that support circuit breaking report the outcome of deliveries to it, and
+// avoid peers whose breaker is open.  Breakers is safe for concurrent use.  A
+// nil *Breakers admits every peer.
+type Breakers struct {
+	cfg BreakerCfg
+
+	sync.Mutex
+	peers map[portal.ID]*breaker
+}
+
+// NewBreakers allocates a set of circuit breakers
+func NewBreakers(cfg BreakerCfg) *Breakers {
+	if cfg.Failures <= 0 {
+		cfg.Failures = 1
+	}
+
+	return &Breakers{cfg: cfg, peers: make(map[portal.ID]*breaker)}
+}
+
+func (b *Breakers) get(id portal.ID) *breaker {
+	br, ok := b.peers[id]
+	if !ok {
+		br = &breaker{since: time.Now()}
+		b.peers[id] = br
+	}
+	return br
+}
+
+// Allow returns true if a message may be sent to the peer.  Once an open
+// breaker's cooldown has elapsed, Allow admits a single probe.  Another probe
+// is admitted if the outcome of the first is not reported within the cooldown.
+func (b *Breakers) Allow(id portal.ID) bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	br := b.get(id)
+	if br.state == BreakerClosed {
+		return true
+	}
+
+	if time.Since(br.since) < b.cfg.Cooldown {
+		return false
+	}
+
+	br.state = BreakerHalfOpen
+	br.since = time.Now()
+	return true
+}
+
+// Tripped returns true if the peer is currently excluded.  Unlike Allow, it
+// does not admit probes.
+func (b *Breakers) Tripped(id portal.ID) bool {
+	if b == nil {
+		return false
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	br, ok := b.peers[id]
+	return ok && br.state != BreakerClosed && time.Since(br.since) < b.cfg.Cooldown
+}
+
+// Success reports a successful delivery to the peer, which closes its breaker
+func (b *Breakers) Success(id portal.ID) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	br := b.get(id)
+	if br.state != BreakerClosed {
+		br.since = time.Now()
+	}
+
+	br.state = BreakerClosed
+	br.failures = 0
+}
+
+// Failure reports a failed delivery to the peer.  The breaker opens once the
+// number of consecutive failures reaches the threshold, or if a probe failed.
+func (b *Breakers) Failure(id portal.ID) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	br := b.get(id)
+	if br.failures++; br.failures >= b.cfg.Failures || br.state == BreakerHalfOpen {
+		br.state = BreakerOpen
+		br.since = time.Now()
+	}
+}
+
+// Remove the peer's breaker, e.g. because the peer disconnected
+func (b *Breakers) Remove(id portal.ID) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	delete(b.peers, id)
+	b.Unlock()
+}
+
+// Cooldown returns the configured cooldown
+func (b *Breakers) Cooldown() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	return b.cfg.Cooldown
+}
+
+// States returns a snapshot of the breakers
+func (b *Breakers) States() []PeerState {
+	if b == nil {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	ss := make([]PeerState, 0, len(b.peers))
+	for id, br := range b.peers {
+		ss = append(ss, PeerState{
+			ID:       id,
+			State:    br.state,
+			Failures: br.failures,
+			Since:    br.since,
+		})
+	}
+
+	return ss
+}
@@ -0,0 +1,66 @@
+package proto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lthibault/portal"
+)
+
+func TestBreakers(t *testing.T) {
+	const cooldown = time.Millisecond * 10
+
+	b := NewBreakers(BreakerCfg{Failures: 2, Cooldown: cooldown})
+	id := portal.NewID()
+
+	state := func() BreakerState {
+		for _, s := range b.States() {
+			if s.ID == id {
+				return s.State
+			}
+		}
+
+		t.Fatal("peer not found")
+		return 0
+	}
+
+	b.Failure(id)
+	if !b.Allow(id) || state() != BreakerClosed {
+		t.Fatal("breaker tripped early")
+	}
+
+	b.Failure(id)
+	if b.Allow(id) || !b.Tripped(id) || state() != BreakerOpen {
+		t.Fatal("breaker did not trip")
+	}
+
+	time.Sleep(cooldown)
+	if !b.Allow(id) || state() != BreakerHalfOpen {
+		t.Fatal("probe not admitted")
+	}
+
+	if b.Allow(id) {
+		t.Error("second probe admitted")
+	}
+
+	b.Failure(id) // failed probe
+	if b.Allow(id) || state() != BreakerOpen {
+		t.Fatal("breaker did not reopen")
+	}
+
+	time.Sleep(cooldown)
+	b.Allow(id)
+	b.Success(id)
+	if !b.Allow(id) || state() != BreakerClosed {
+		t.Error("peer not readmitted")
+	}
+
+	var nilB *Breakers
+	if !nilB.Allow(id) || nilB.Tripped(id) {
+		t.Error("nil breakers excluded a peer")
+	}
+
+	if ss := nilB.States(); ss != nil || nilB.Cooldown() != 0 {
+		t.Errorf("unexpected state for nil breakers %v", ss)
+	}
+}
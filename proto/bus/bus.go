@@ -1,9 +1,6 @@
 package bus
 
 import (
-	"sync"
-
-	"github.com/SentimensRG/ctx"
 	"github.com/lthibault/portal"
 	proto "github.com/lthibault/portal/proto"
 )
@@ -14,8 +11,9 @@ type msgSender interface {
 
 type busEP struct {
 	portal.Endpoint
-	q   chan *portal.Message
-	bus *Protocol
+	q     chan *portal.Message
+	bus   *Protocol
+	limit *portal.Limiter
 }
 
 func (b busEP) close() {
@@ -27,7 +25,7 @@ func (b busEP) sendMsg(msg *portal.Message) {
 	select {
 	case b.q <- msg:
 	case <-b.Done():
-		msg.Free()
+		b.bus.ptl.Drop(msg, portal.ErrClosed)
 	}
 }
 
@@ -35,36 +33,30 @@ func (b busEP) startSending() {
 	rq := b.RecvChannel()
 	cq := b.Done()
 	for msg := range b.q {
-		select {
-		case rq <- msg:
-		case <-cq:
-			msg.Free()
+		if !b.limit.Wait(b.Endpoint) {
+			b.bus.ptl.Drop(msg, portal.ErrClosed)
 			return
 		}
-	}
-}
 
-func (b busEP) startReceiving() {
-	rq := b.bus.ptl.RecvChannel()
-	cq := ctx.Link(ctx.Lift(b.bus.ptl.CloseChannel()), b)
-
-	for msg := range b.SendChannel() {
-		id := b.ID()
-		msg.From = &id
+		if msg.Expired() {
+			b.bus.ptl.Drop(msg, portal.ErrExpired)
+			continue
+		}
 
 		select {
+		case rq <- msg:
 		case <-cq:
-			msg.Free()
+			b.bus.ptl.Drop(msg, portal.ErrClosed)
 			return
-		case rq <- msg:
 		}
 	}
 }
 
 // Protocol implementing BUS
 type Protocol struct {
-	ptl portal.ProtocolPortal
-	n   proto.Neighborhood
+	ptl   portal.ProtocolPortal
+	n     proto.Neighborhood
+	limit portal.Limit
 }
 
 // Init the protocol (called by portal)
@@ -75,8 +67,6 @@ func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 }
 
 func (p Protocol) startSending() {
-	var wg sync.WaitGroup
-
 	sq := p.ptl.SendChannel()
 	cq := p.ptl.CloseChannel()
 
@@ -92,41 +82,39 @@ func (p Protocol) startSending() {
 				panic("ensure portal.Doner fires closes before chSend/chRecv")
 			}
 
-			p.broadcast(&wg, msg).Wait()
+			p.broadcast(msg)
 		}
 	}
 }
 
-func (p Protocol) broadcast(wg *sync.WaitGroup, msg *portal.Message) *sync.WaitGroup {
+func (p Protocol) broadcast(msg *portal.Message) {
 	m, done := p.n.RMap() // get a read-locked map-view of the Neighborhood
 	defer done()
 
 	for id, peer := range m {
 		// if there's a header, it means the msg was rebroadcast
-		if id == *msg.From {
+		if msg.From != nil && id == *msg.From {
 			continue
 		}
 
 		// proto.Neighborhood stores portal.Endpoints, so we must type-assert
-		go p.unicast(wg, peer.(msgSender), msg)
+		peer.(msgSender).sendMsg(msg.Share())
 	}
 
-	return wg
-}
-
-func (p Protocol) unicast(wg *sync.WaitGroup, s msgSender, msg *portal.Message) {
-	wg.Add(1)
-	s.sendMsg(msg.Ref())
-	wg.Done()
+	msg.Free()
 }
 
 func (p *Protocol) AddEndpoint(ep portal.Endpoint) {
 	proto.MustBeCompatible(p, ep.Signature())
 
-	pe := &busEP{Endpoint: ep, q: make(chan *portal.Message, 1), bus: p}
+	pe := &busEP{
+		Endpoint: ep,
+		q:        make(chan *portal.Message, 1),
+		bus:      p,
+		limit:    portal.NewLimiter(p.limit),
+	}
 	p.n.SetPeer(ep.ID(), pe)
-	go pe.startSending()
-	go pe.startReceiving()
+	go pe.startSending() // peers deliver directly to this portal's RecvChannel
 }
 
 func (p Protocol) RemoveEndpoint(ep portal.Endpoint) { p.n.DropPeer(ep.ID()) }
@@ -138,5 +126,5 @@ func (Protocol) PeerName() string   { return "bus" }
 
 // New allocates a portal using the BUS protocol
 func New(cfg portal.Cfg) portal.Portal {
-	return portal.MakePortal(cfg, &Protocol{})
+	return portal.MakePortal(cfg, &Protocol{limit: cfg.PeerLimit})
 }
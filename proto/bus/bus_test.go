@@ -100,3 +100,47 @@ func TestIntegration(t *testing.T) {
 		}
 	})
 }
+
+func TestPeerLimit(t *testing.T) {
+	const (
+		nMsgs = 5
+		rate  = 50 // per second
+	)
+
+	bP := New(portal.Cfg{Size: nMsgs, PeerLimit: portal.Limit{Rate: rate}})
+	defer bP.Close()
+
+	if err := bP.Bind("/test/bus/limit"); err != nil {
+		t.Fatal(err)
+	}
+
+	cP := New(portal.Cfg{})
+	defer cP.Close()
+
+	if err := cP.Connect("/test/bus/limit"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < nMsgs; i++ {
+		bP.Send(i)
+	}
+
+	done := make(chan time.Duration)
+	go func() {
+		for i := 0; i < nMsgs; i++ {
+			cP.Recv()
+		}
+		done <- time.Since(start)
+	}()
+
+	min := time.Second * (nMsgs - 1) / rate
+	select {
+	case d := <-done:
+		if d < min*9/10 {
+			t.Errorf("peer received %d values in %s (expected at least %s)", nMsgs, d, min)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer did not receive all values")
+	}
+}
@@ -27,8 +27,8 @@ func (p *Protocol) AddEndpoint(ep portal.Endpoint) {
 		ep.Close()
 	} else {
 		p.peer = ep
-		go p.startReceiving()
-		go p.startSending()
+		go p.startReceiving(ep)
+		go p.startSending(ep)
 	}
 }
 
@@ -48,11 +48,13 @@ func (*Protocol) Name() string       { return "pair" }
 func (*Protocol) PeerNumber() uint16 { return proto.Pair }
 func (*Protocol) PeerName() string   { return "pair" }
 
-func (p *Protocol) startReceiving() {
+func (p *Protocol) startReceiving(peer portal.Endpoint) {
+	defer p.ptl.Recover(peer)
+
 	rq := p.ptl.RecvChannel()
 	cq := p.ptl.CloseChannel()
 
-	for msg := range p.peer.SendChannel() {
+	for msg := range peer.SendChannel() {
 		select {
 		case rq <- msg:
 		case <-cq:
@@ -62,12 +64,14 @@ func (p *Protocol) startReceiving() {
 	}
 }
 
-func (p *Protocol) startSending() {
+func (p *Protocol) startSending(peer portal.Endpoint) {
+	defer p.ptl.Recover(peer)
+
 	sq := p.ptl.SendChannel()
 	cq := p.ptl.CloseChannel()
 
-	prq := p.peer.RecvChannel()
-	pcq := p.peer.Done()
+	prq := peer.RecvChannel()
+	pcq := peer.Done()
 
 	// This is pretty easy because we have only one peer at a time.
 	// If the peer goes away, drop the message on the floor.
@@ -80,7 +84,7 @@ func (p *Protocol) startSending() {
 				select {
 				case prq <- msg:
 				case <-pcq:
-					msg.Free()
+					p.ptl.Drop(msg, portal.ErrClosed)
 					return
 				}
 			}
@@ -23,6 +23,10 @@ const (
 	Deal
 )
 
+// HeaderRequestID correlates a reply with its request.  It is set by REQ
+// portals that retry or hedge requests, and copied to the reply by REP.
+const HeaderRequestID = "portal.request-id" // uint64
+
 // EndpointsCompatible returns true if the Endpoints have compatible protocols
 func EndpointsCompatible(sig0, sig1 portal.ProtocolSignature) bool {
 	return sig0.Number() == sig1.PeerNumber() && sig0.Number() == sig1.PeerNumber()
@@ -35,24 +39,43 @@ func MustBeCompatible(sig0, sig1 portal.ProtocolSignature) {
 	}
 }
 
-// // PeerEndpoint is the endpoint to a remote peer.
-// type PeerEndpoint interface {
-// 	ctx.Doner
-// 	portal.Endpoint
-// }
+// PeerEndpoint is the endpoint to a remote peer.
+type PeerEndpoint interface {
+	portal.Endpoint
+
+	// Notify delivers a message to the peer.  If the peer goes away first,
+	// the message is freed.
+	Notify(*portal.Message)
+
+	// Announce returns the next message sent by the peer, or nil if the peer
+	// has gone away.
+	Announce() *portal.Message
+}
+
+type peerEP struct{ portal.Endpoint }
 
-// type peerEP struct {
-// 	portal.Endpoint
-// 	cq chan struct{}
-// }
+// NewPeerEP wraps an endpoint in a PeerEndpoint
+func NewPeerEP(ep portal.Endpoint) PeerEndpoint { return peerEP{ep} }
 
-// // NewPeerEP wraps an endpoint in a PeerEndpoint
-// func NewPeerEP(ep portal.Endpoint) PeerEndpoint {
-// 	return &peerEP{Endpoint: ep, cq: make(chan struct{})}
-// }
+func (p peerEP) Notify(msg *portal.Message) {
+	select {
+	case p.RecvChannel() <- msg:
+	case <-p.Done():
+		msg.Free()
+	}
+}
 
-// func (p peerEP) Done() <-chan struct{} { return p.cq }
-// func (p peerEP) Close()                { close(p.cq) }
+func (p peerEP) Announce() *portal.Message {
+	select {
+	case msg, ok := <-p.SendChannel():
+		if ok {
+			return msg
+		}
+	case <-p.Done():
+	}
+
+	return nil
+}
 
 // Neighborhood maintains a map of portal.Endpoints
 type Neighborhood interface {
@@ -65,17 +88,20 @@ type Neighborhood interface {
 // neighborhood stores connected peer endpoints
 type neighborhood struct {
 	sync.RWMutex
-	epts map[portal.ID]portal.Endpoint
+	epts    map[portal.ID]portal.Endpoint
+	runlock func() // n.RUnlock, bound once so that RMap does not allocate
 }
 
 // NewNeighborhood initializes a Neighborhood
 func NewNeighborhood() Neighborhood {
-	return &neighborhood{epts: make(map[portal.ID]portal.Endpoint)}
+	n := &neighborhood{epts: make(map[portal.ID]portal.Endpoint)}
+	n.runlock = n.RUnlock
+	return n
 }
 
 func (n *neighborhood) RMap() (map[portal.ID]portal.Endpoint, func()) {
 	n.RLock()
-	return n.epts, n.RUnlock
+	return n.epts, n.runlock
 }
 
 func (n *neighborhood) SetPeer(id portal.ID, pe portal.Endpoint) {
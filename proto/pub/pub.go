@@ -7,16 +7,149 @@ import (
 	proto "github.com/lthibault/portal/proto"
 )
 
+type pubEP struct {
+	portal.Endpoint
+	sync.Mutex // serializes enqueuing with draining the queue on close
+	q          chan *portal.Message
+	ptl        portal.ProtocolPortal
+	limit      *portal.Limiter
+	backlog    []entry // retained messages, replayed before live traffic
+	copy       bool    // replay a deep copy of each retained value
+}
+
+func (pe *pubEP) sendMsg(msg *portal.Message) {
+	pe.Lock()
+	defer pe.Unlock()
+
+	select {
+	case <-pe.Done():
+		pe.ptl.Drop(msg, portal.ErrClosed)
+		return
+	default:
+	}
+
+	select {
+	case pe.q <- msg:
+	case <-pe.Done():
+		pe.ptl.Drop(msg, portal.ErrClosed)
+	}
+}
+
+func (pe *pubEP) startSending() {
+	defer pe.drain()
+
+	for _, e := range pe.backlog {
+		if !pe.deliver(e.message(pe.copy)) {
+			return
+		}
+	}
+
+	cq := pe.Done()
+	for {
+		select {
+		case <-cq:
+			return
+		case msg := <-pe.q:
+			if !pe.deliver(msg) {
+				return
+			}
+		}
+	}
+}
+
+// drain the queue once the peer has gone away.  Since sendMsg checks Done while
+// holding the lock, nothing is enqueued afterwards.
+func (pe *pubEP) drain() {
+	pe.Lock()
+	defer pe.Unlock()
+
+	for {
+		select {
+		case msg := <-pe.q:
+			pe.ptl.Drop(msg, portal.ErrClosed)
+		default:
+			return
+		}
+	}
+}
+
+// deliver a message to the peer, returning false if the peer went away
+func (pe *pubEP) deliver(msg *portal.Message) bool {
+	if !pe.limit.Wait(pe.Endpoint) {
+		pe.ptl.Drop(msg, portal.ErrClosed)
+		return false
+	}
+
+	if msg.Expired() {
+		pe.ptl.Drop(msg, portal.ErrExpired)
+		return true
+	}
+
+	select {
+	case pe.RecvChannel() <- msg:
+		return true
+	case <-pe.Done():
+		pe.ptl.Drop(msg, portal.ErrClosed)
+		return false
+	}
+}
+
+// peerSet is a copy-on-write list of peers, so that publishing a message does
+// not allocate
+type peerSet struct {
+	sync.RWMutex
+	eps []*pubEP
+}
+
+func (s *peerSet) load() []*pubEP {
+	s.RLock()
+	defer s.RUnlock()
+	return s.eps
+}
+
+func (s *peerSet) add(pe *pubEP) {
+	s.Lock()
+	defer s.Unlock()
+
+	eps := make([]*pubEP, 0, len(s.eps)+1)
+	for _, e := range s.eps {
+		if e.ID() != pe.ID() {
+			eps = append(eps, e)
+		}
+	}
+	s.eps = append(eps, pe)
+}
+
+func (s *peerSet) remove(id portal.ID) {
+	s.Lock()
+	defer s.Unlock()
+
+	eps := make([]*pubEP, 0, len(s.eps))
+	for _, e := range s.eps {
+		if e.ID() != id {
+			eps = append(eps, e)
+		}
+	}
+	s.eps = eps
+}
+
 // Protocol implementing PUB
 type Protocol struct {
-	ptl portal.ProtocolPortal
-	n   proto.Neighborhood
+	ptl      portal.ProtocolPortal
+	n        proto.Neighborhood
+	peers    *peerSet
+	limit    portal.Limit
+	retained *retention
 }
 
 // Init the Protocol
 func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 	p.ptl = ptl
 	p.n = proto.NewNeighborhood()
+	p.peers = new(peerSet)
+	if p.retained == nil {
+		p.retained = new(retention)
+	}
 	go p.startSending()
 }
 
@@ -24,8 +157,6 @@ func (p Protocol) startSending() {
 	cq := p.ptl.CloseChannel()
 	sq := p.ptl.SendChannel()
 
-	var wg sync.WaitGroup
-
 	for {
 		select {
 		case <-cq:
@@ -38,18 +169,19 @@ func (p Protocol) startSending() {
 				panic("ensure portal.Doner fires closes before chSend/chRecv")
 			}
 
-			m, done := p.n.RMap()
-			wg.Add(len(m))
+			// Retain the message and list the recipients atomically, so that
+			// peers added concurrently receive each message exactly once.
+			p.retained.Lock()
+			if p.retained.enabled() {
+				p.retained.add(msg)
+			}
+			peers := p.peers.load()
+			p.retained.Unlock()
 
-			for _, peer := range m {
-				go func(p portal.Endpoint) {
-					p.RecvChannel() <- msg.Ref()
-					wg.Done()
-				}(peer)
+			for _, pe := range peers {
+				pe.sendMsg(msg.Share())
 			}
 
-			done()
-			wg.Wait()
 			msg.Free()
 		}
 	}
@@ -57,10 +189,30 @@ func (p Protocol) startSending() {
 
 func (p Protocol) AddEndpoint(ep portal.Endpoint) {
 	proto.MustBeCompatible(p, ep.Signature())
-	p.n.SetPeer(ep.ID(), ep)
+
+	pe := &pubEP{
+		Endpoint: ep,
+		q:        make(chan *portal.Message, 1),
+		ptl:      p.ptl,
+		limit:    portal.NewLimiter(p.limit),
+	}
+
+	p.retained.Lock()
+	if p.retained.enabled() {
+		pe.backlog = p.retained.snapshot()
+		pe.copy = p.retained.copy
+	}
+	p.n.SetPeer(ep.ID(), pe)
+	p.peers.add(pe)
+	p.retained.Unlock()
+
+	go pe.startSending()
 }
 
-func (p Protocol) RemoveEndpoint(ep portal.Endpoint) { p.n.DropPeer(ep.ID()) }
+func (p Protocol) RemoveEndpoint(ep portal.Endpoint) {
+	p.peers.remove(ep.ID())
+	p.n.DropPeer(ep.ID())
+}
 
 func (Protocol) Number() uint16     { return proto.Pub }
 func (Protocol) PeerNumber() uint16 { return proto.Sub }
@@ -68,6 +220,14 @@ func (Protocol) Name() string       { return "pub" }
 func (Protocol) PeerName() string   { return "sub" }
 
 // New allocates a portal using the PUB protocol
-func New(cfg portal.Cfg) portal.WriteOnly {
-	return struct{ portal.WriteOnly }{portal.MakePortal(cfg, &Protocol{})} // write guard
+func New(cfg portal.Cfg, opt ...Option) portal.WriteOnly {
+	p := &Protocol{
+		limit:    cfg.PeerLimit,
+		retained: &retention{copy: cfg.CopyOnSend && cfg.Codec == nil},
+	}
+	for _, o := range opt {
+		o(p)
+	}
+
+	return portal.NewWriteGuard(portal.MakePortal(cfg, p))
 }
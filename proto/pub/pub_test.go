@@ -0,0 +1,339 @@
+package pub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/codec"
+	"github.com/lthibault/portal/proto/sub"
+)
+
+func TestCopyOnSend(t *testing.T) {
+	const nSubs = 2
+
+	p := New(portal.Cfg{CopyOnSend: true})
+	defer p.Close()
+
+	if err := p.Bind("/test/pub/copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	subs := make([]sub.Portal, nSubs)
+	for i := range subs {
+		subs[i] = sub.New(portal.Cfg{})
+		defer subs[i].Close()
+
+		if err := subs[i].Subscribe(sub.TopicAll); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := subs[i].Connect("/test/pub/copy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := []int{0}
+	go p.Send(v)
+
+	ch := make(chan []int, nSubs)
+	for _, s := range subs {
+		go func(s sub.Portal) { ch <- s.Recv().([]int) }(s)
+	}
+
+	var got [][]int
+	for i := 0; i < nSubs; i++ {
+		select {
+		case r := <-ch:
+			got = append(got, r)
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("subscriber did not receive value")
+		}
+	}
+
+	got[0][0] = 1
+	if got[1][0] != 0 || v[0] != 0 {
+		t.Error("subscribers share the same value")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	recv := func(t *testing.T, s sub.Portal, expect ...interface{}) {
+		for _, v := range expect {
+			ch := make(chan interface{}, 1)
+			go func() { ch <- s.Recv() }()
+
+			select {
+			case got := <-ch:
+				if got != v {
+					t.Errorf("expected %v, got %v", v, got)
+				}
+			case <-time.After(time.Millisecond * 100):
+				t.Fatalf("did not receive %v", v)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		opt    []Option
+		send   []string
+		expect []interface{}
+	}{{
+		name:   "Last",
+		opt:    []Option{RetainLast(2)},
+		send:   []string{"a1", "b1", "a2"},
+		expect: []interface{}{"b1", "a2"},
+	}, {
+		name:   "Latest",
+		opt:    []Option{RetainLatest(func(v interface{}) interface{} { return v.(string)[0] })},
+		send:   []string{"a1", "b1", "a2", "c1"},
+		expect: []interface{}{"b1", "a2", "c1"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := "/test/pub/retention/" + tc.name
+
+			p := New(portal.Cfg{}, tc.opt...)
+			defer p.Close()
+
+			if err := p.Bind(addr); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, v := range tc.send {
+				p.Send(v) // no subscribers yet
+			}
+
+			s := sub.New(portal.Cfg{})
+			defer s.Close()
+
+			if err := s.Subscribe(sub.TopicAll); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Connect(addr); err != nil {
+				t.Fatal(err)
+			}
+
+			recv(t, s, tc.expect...)
+
+			go p.Send("live")
+			recv(t, s, "live")
+		})
+	}
+
+	t.Run("Copy", func(t *testing.T) {
+		p := New(portal.Cfg{CopyOnSend: true}, RetainLast(1))
+		defer p.Close()
+
+		if err := p.Bind("/test/pub/retention/copy"); err != nil {
+			t.Fatal(err)
+		}
+
+		v := []int{0}
+		p.Send(v) // no subscribers yet
+
+		var got [][]int
+		for i := 0; i < 2; i++ {
+			s := sub.New(portal.Cfg{})
+			defer s.Close()
+
+			if err := s.Subscribe(sub.TopicAll); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Connect("/test/pub/retention/copy"); err != nil {
+				t.Fatal(err)
+			}
+
+			ch := make(chan interface{}, 1)
+			go func() { ch <- s.Recv() }()
+
+			select {
+			case r := <-ch:
+				got = append(got, r.([]int))
+			case <-time.After(time.Millisecond * 100):
+				t.Fatal("did not receive retained value")
+			}
+		}
+
+		got[0][0] = 1
+		if got[1][0] != 0 || v[0] != 0 {
+			t.Error("late subscribers share the same value")
+		}
+	})
+
+	t.Run("IncomparableKey", func(t *testing.T) {
+		r := retention{key: func(v interface{}) interface{} { return v }}
+
+		msg := portal.NewMsg()
+		defer msg.Free()
+
+		msg.Value = []int{0}
+		r.add(msg) // must not panic
+		r.add(msg)
+
+		if n := len(r.snapshot()); n != 0 {
+			t.Errorf("expected value with incomparable key to be skipped, got %d", n)
+		}
+	})
+
+	t.Run("For", func(t *testing.T) {
+		r := retention{age: time.Millisecond}
+		msg := portal.NewMsg()
+		defer msg.Free()
+
+		r.add(msg)
+		time.Sleep(time.Millisecond * 2)
+
+		if n := len(r.snapshot()); n != 0 {
+			t.Errorf("expected expired entries to be evicted, got %d", n)
+		}
+	})
+}
+
+func TestPeerLimit(t *testing.T) {
+	const (
+		nSubs = 2
+		nMsgs = 5
+		rate  = 50 // per second
+	)
+
+	p := New(portal.Cfg{Size: nMsgs, PeerLimit: portal.Limit{Rate: rate}})
+	defer p.Close()
+
+	if err := p.Bind("/test/pub/limit"); err != nil {
+		t.Fatal(err)
+	}
+
+	subs := make([]sub.Portal, nSubs)
+	for i := range subs {
+		subs[i] = sub.New(portal.Cfg{})
+		defer subs[i].Close()
+
+		if err := subs[i].Subscribe(sub.TopicAll); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := subs[i].Connect("/test/pub/limit"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	for i := 0; i < nMsgs; i++ {
+		p.Send(i)
+	}
+
+	done := make(chan time.Duration, nSubs)
+	for _, s := range subs {
+		go func(s sub.Portal) {
+			for i := 0; i < nMsgs; i++ {
+				s.Recv()
+			}
+			done <- time.Since(start)
+		}(s)
+	}
+
+	// each peer has its own bucket, holding a single token
+	min := time.Second * (nMsgs - 1) / rate
+	for i := 0; i < nSubs; i++ {
+		select {
+		case d := <-done:
+			if d < min*9/10 {
+				t.Errorf("peer received %d values in %s (expected at least %s)", nMsgs, d, min)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("subscriber did not receive all values")
+		}
+	}
+}
+
+func TestSubClose(t *testing.T) {
+	p := New(portal.Cfg{})
+	defer p.Close()
+
+	if err := p.Bind("/test/pub/close"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := sub.New(portal.Cfg{})
+	if err := s.Subscribe(sub.TopicAll); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Connect("/test/pub/close"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			p.Send(i)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("Send blocked after subscriber closed")
+	}
+}
+
+func TestTopic(t *testing.T) {
+	isA := sub.TopicFunc(func(v interface{}) bool { return v == "a" })
+
+	for _, tc := range []struct {
+		name string
+		cfg  portal.Cfg
+	}{
+		{"Value", portal.Cfg{}},
+		{"Codec", portal.Cfg{Codec: codec.JSON{}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := "/test/pub/topic/" + tc.name
+
+			p := New(tc.cfg)
+			defer p.Close()
+
+			if err := p.Bind(addr); err != nil {
+				t.Fatal(err)
+			}
+
+			s := sub.New(tc.cfg)
+			defer s.Close()
+
+			if err := s.Subscribe(isA); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Connect(addr); err != nil {
+				t.Fatal(err)
+			}
+
+			go func() {
+				p.Send("b")
+				p.Send("a")
+			}()
+
+			ch := make(chan interface{}, 1)
+			go func() { ch <- s.Recv() }()
+
+			select {
+			case v := <-ch:
+				if v != "a" {
+					t.Errorf("expected a, got %v", v)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("value not received")
+			}
+
+			if n := s.Stats().Dropped; n != 0 {
+				t.Errorf("expected unsubscribed values not to count as dropped, got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package pub
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/lthibault/portal"
+)
+
+// Option configures the PUB protocol
+type Option func(*Protocol)
+
+// RetainLast retains the last n messages, which are replayed to subscribers
+// that connect later.
+func RetainLast(n int) Option {
+	return func(p *Protocol) { p.retained.last = n }
+}
+
+// RetainFor retains messages for the specified duration, replaying them to
+// subscribers that connect later.
+func RetainFor(d time.Duration) Option {
+	return func(p *Protocol) { p.retained.age = d }
+}
+
+// RetainLatest retains the last message published on each topic, as identified
+// by the key function.  It is intended for broadcasting configuration and
+// state, where late subscribers need the current value of each topic.  It may
+// be combined with RetainLast and RetainFor, which then limit the number and
+// age of the retained topics.  Keys must be comparable;  values whose key is
+// not comparable (e.g. a slice) are not retained.
+func RetainLatest(key func(interface{}) interface{}) Option {
+	return func(p *Protocol) { p.retained.key = key }
+}
+
+type entry struct {
+	at       time.Time
+	key      interface{}
+	value    interface{}
+	header   portal.Header
+	priority int
+	deadline time.Time
+}
+
+func (e entry) message(copyValue bool) *portal.Message {
+	msg := portal.NewDetachedMsg()
+	msg.Value = e.value
+	if copyValue {
+		msg.Value = portal.Clone(e.value)
+	}
+	msg.Priority = e.priority
+	msg.Deadline = e.deadline
+	for k, v := range e.header {
+		msg.Annotate(k, v)
+	}
+	return msg
+}
+
+// retention buffers published values.  Values are copied out of messages, so
+// that the sender is not kept waiting for the message to be freed.
+type retention struct {
+	sync.Mutex
+	last    int
+	age     time.Duration
+	key     func(interface{}) interface{}
+	copy    bool    // replay a deep copy of each value (see Cfg.CopyOnSend)
+	entries []entry // oldest first
+}
+
+func (r *retention) enabled() bool { return r.last > 0 || r.age > 0 || r.key != nil }
+
+func (r *retention) add(msg *portal.Message) {
+	e := entry{
+		at:       time.Now(),
+		value:    msg.Value,
+		priority: msg.Priority,
+		deadline: msg.Deadline,
+	}
+
+	if len(msg.Header) > 0 {
+		e.header = make(portal.Header, len(msg.Header))
+		for k, v := range msg.Header {
+			e.header[k] = v
+		}
+	}
+
+	if r.key != nil {
+		if e.key = r.key(msg.Value); !comparable(e.key) {
+			return
+		}
+
+		for i := range r.entries {
+			if r.entries[i].key == e.key {
+				r.entries = append(r.entries[:i], r.entries[i+1:]...)
+				break
+			}
+		}
+	}
+
+	r.entries = append(r.entries, e)
+	r.evict(e.at)
+}
+
+func (r *retention) evict(now time.Time) {
+	n := 0
+	if r.last > 0 && len(r.entries) > r.last {
+		n = len(r.entries) - r.last
+	}
+
+	for r.age > 0 && n < len(r.entries) && now.Sub(r.entries[n].at) > r.age {
+		n++
+	}
+
+	if n > 0 {
+		r.entries = append(r.entries[:0], r.entries[n:]...)
+	}
+}
+
+// snapshot returns the retained entries, oldest first
+func (r *retention) snapshot() []entry {
+	r.evict(time.Now())
+	return append([]entry(nil), r.entries...)
+}
+
+// comparable returns false if comparing the key would panic
+func comparable(key interface{}) bool {
+	return key == nil || reflect.ValueOf(key).Comparable()
+}
@@ -1,42 +1,149 @@
 package pull
 
 import (
+	"sync"
+
 	"github.com/lthibault/portal"
 	"github.com/lthibault/portal/proto"
 )
 
 // Protocol implementing PULL
-type Protocol struct{ ptl portal.ProtocolPortal }
+type Protocol struct {
+	ptl portal.ProtocolPortal
+
+	sync.RWMutex
+	peers map[portal.ID]portal.Endpoint
+
+	reorder *reorderer // nil unless the Reorder option is set
+}
 
 // Init the PULL protocol
-func (p *Protocol) Init(ptl portal.ProtocolPortal) { p.ptl = ptl }
+func (p *Protocol) Init(ptl portal.ProtocolPortal) {
+	p.ptl = ptl
+	p.peers = make(map[portal.ID]portal.Endpoint)
+
+	if p.reorder != nil {
+		go p.reorder.run(ptl, ptl.RecvChannel())
+	}
+}
+
+// RecvQueue implements portal.ProtocolRecvQueue
+func (p *Protocol) RecvQueue() chan<- *portal.Message {
+	if p.reorder == nil {
+		return nil
+	}
+	return p.reorder.in
+}
+
+func (p *Protocol) startReceiving(ep portal.Endpoint) {
+	var msg *portal.Message
+	defer p.ptl.Recover(ep)
+	defer func() {
+		if msg != nil {
+			p.ptl.Drop(msg, portal.ErrClosed) // in flight when we stopped
+		}
+	}()
 
-func (p Protocol) startReceiving(ep portal.Endpoint) {
 	rq := p.ptl.RecvChannel()
 	cq := p.ptl.CloseChannel()
 
-	for msg := range ep.SendChannel() {
+	for msg = range ep.SendChannel() {
 		select {
 		case <-cq:
 			return
 		case rq <- msg:
+			msg = nil
 		}
 	}
 }
 
-func (Protocol) Number() uint16                    { return proto.Pull }
-func (Protocol) Name() string                      { return "pull" }
-func (Protocol) PeerNumber() uint16                { return proto.Push }
-func (Protocol) PeerName() string                  { return "push" }
-func (Protocol) RemoveEndpoint(ep portal.Endpoint) {}
+// Requeue hands a message that was not acknowledged back to a PUSH peer, which
+// delivers it to another PULL portal if possible.
+func (p *Protocol) Requeue(msg *portal.Message) bool {
+	p.RLock()
+	eps := make([]portal.Endpoint, 0, len(p.peers))
+	for _, ep := range p.peers {
+		eps = append(eps, ep)
+	}
+	p.RUnlock()
+
+	for _, ep := range eps {
+		if ep.Redeliver(msg, p.ptl.ID()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeadLetter implements portal.ProtocolDeadLetterSink
+func (p *Protocol) DeadLetter(msg *portal.Message) bool {
+	select {
+	case p.ptl.RecvChannel() <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (*Protocol) Number() uint16     { return proto.Pull }
+func (*Protocol) Name() string       { return "pull" }
+func (*Protocol) PeerNumber() uint16 { return proto.Push }
+func (*Protocol) PeerName() string   { return "push" }
 
-func (p Protocol) AddEndpoint(ep portal.Endpoint) {
+func (p *Protocol) AddEndpoint(ep portal.Endpoint) {
 	proto.MustBeCompatible(p, ep.Signature())
+
+	p.Lock()
+	p.peers[ep.ID()] = ep
+	p.Unlock()
+
 	go p.startReceiving(ep)
 }
 
+func (p *Protocol) RemoveEndpoint(ep portal.Endpoint) {
+	p.Lock()
+	delete(p.peers, ep.ID())
+	p.Unlock()
+}
+
+func newProtocol(opt []Option) *Protocol {
+	p := new(Protocol)
+	for _, o := range opt {
+		o(p)
+	}
+	return p
+}
+
 // New allocates a Portal using the PULL protocol
-func New(cfg portal.Cfg) portal.ReadOnly {
-	return struct{ portal.ReadOnly }{portal.MakePortal(cfg, &Protocol{})} // read guard
+func New(cfg portal.Cfg, opt ...Option) portal.ReadOnly {
+	return portal.NewReadGuard(portal.MakePortal(cfg, newProtocol(opt)))
+}
+
+// AckPortal is a PULL portal in manual-ack mode
+type AckPortal interface {
+	portal.Transporter
+
+	// Recv returns a delivery handle, which must be acknowledged.  Values that
+	// are not acknowledged within Cfg.AckTimeout are redelivered to another
+	// PULL portal connected to the same PUSH portal.
+	Recv() *portal.Delivery
+}
+
+// deliverer is implemented by the portal returned by portal.MakePortal
+type deliverer interface {
+	RecvDelivery() *portal.Delivery
+}
+
+type ackPortal struct {
+	portal.Guard
+	d deliverer
+}
+
+func (p ackPortal) Recv() *portal.Delivery { return p.d.RecvDelivery() }
 
+// NewAck allocates a Portal using the PULL protocol in manual-ack mode
+func NewAck(cfg portal.Cfg, opt ...Option) AckPortal {
+	ptl := portal.MakePortal(cfg, newProtocol(opt))
+	return ackPortal{Guard: portal.NewGuard(ptl), d: ptl.(deliverer)}
 }
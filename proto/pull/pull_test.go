@@ -0,0 +1,116 @@
+package pull
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/push"
+)
+
+func TestReorder(t *testing.T) {
+	recv := func(t *testing.T, p portal.ReadOnly) interface{} {
+		ch := make(chan interface{}, 1)
+		go func() { ch <- p.Recv() }()
+
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("value not received")
+			return nil
+		}
+	}
+
+	mkPortals := func(t *testing.T, addr string, size int) (portal.WriteOnly, portal.ReadOnly) {
+		p := push.New(portal.Cfg{Size: 8, Sequence: true})
+		if err := p.Bind(addr); err != nil {
+			t.Fatal(err)
+		}
+
+		pl := New(portal.Cfg{}, Reorder(size, time.Millisecond*10))
+		if err := pl.Connect(addr); err != nil {
+			t.Fatal(err)
+		}
+
+		return p, pl
+	}
+
+	t.Run("Order", func(t *testing.T) {
+		p, pl := mkPortals(t, "/test/pull/reorder/order", 8)
+		defer p.Close()
+		defer pl.Close()
+
+		for _, seq := range []uint64{3, 1, 4, 2} {
+			p.Send(portal.Sequenced{Seq: seq, Value: seq})
+		}
+
+		for i := uint64(1); i <= 4; i++ {
+			if v := recv(t, pl); v != i {
+				t.Errorf("expected %d, got %v", i, v)
+			}
+		}
+	})
+
+	t.Run("Gap", func(t *testing.T) {
+		p, pl := mkPortals(t, "/test/pull/reorder/gap", 8)
+		defer p.Close()
+		defer pl.Close()
+
+		p.Send(portal.Sequenced{Seq: 2, Value: uint64(2)}) // 1 is never sent
+		if v := recv(t, pl); v != uint64(2) {
+			t.Errorf("expected 2, got %v", v)
+		}
+
+		p.Send(portal.Sequenced{Seq: 1, Value: uint64(1)}) // late
+		if v := recv(t, pl); v != uint64(1) {
+			t.Errorf("expected 1, got %v", v)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		p, pl := mkPortals(t, "/test/pull/reorder/duplicate", 8)
+		defer p.Close()
+		defer pl.Close()
+
+		p.Send(portal.Sequenced{Seq: 2, Value: "stale"})
+		p.Send(portal.Sequenced{Seq: 2, Value: "fresh"})
+		p.Send(portal.Sequenced{Seq: 1, Value: "first"})
+
+		if v := recv(t, pl); v != "first" {
+			t.Errorf("expected first, got %v", v)
+		}
+
+		if v := recv(t, pl); v != "fresh" {
+			t.Errorf("expected fresh, got %v", v)
+		}
+
+		ch := make(chan interface{}, 1)
+		go func() { ch <- pl.Recv() }()
+
+		select {
+		case v := <-ch:
+			t.Errorf("duplicate delivered (%v)", v)
+		case <-time.After(time.Millisecond * 20):
+		}
+	})
+
+	t.Run("Full", func(t *testing.T) {
+		p, pl := mkPortals(t, "/test/pull/reorder/full", 2)
+		defer p.Close()
+		defer pl.Close()
+
+		for _, seq := range []uint64{3, 4} {
+			p.Send(portal.Sequenced{Seq: seq, Value: seq})
+		}
+
+		start := time.Now()
+		if v := recv(t, pl); v != uint64(3) {
+			t.Errorf("expected 3, got %v", v)
+		}
+
+		if time.Since(start) >= time.Millisecond*10 {
+			t.Error("full buffer did not skip the gap")
+		}
+	})
+}
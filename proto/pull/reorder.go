@@ -0,0 +1,143 @@
+package pull
+
+import (
+	"time"
+
+	"github.com/lthibault/portal"
+	"github.com/pkg/errors"
+)
+
+// ErrDuplicate is the reason given when a buffered message is replaced by
+// another with the same sequence number.
+var ErrDuplicate = errors.New("duplicate sequence number")
+
+// Option configures the PULL protocol
+type Option func(*Protocol)
+
+// Reorder delivers messages in the order of their sequence numbers (see
+// portal.Cfg.Sequence), starting from 1.  Messages that arrive early are held in
+// a buffer of the specified size.  If a missing message does not arrive within
+// the gap timeout, or if the buffer fills up, the gap is skipped.  Messages that
+// arrive after their gap was skipped, and messages without a sequence number,
+// are delivered immediately.  If a sequence number is received twice before it
+// is delivered, the most recent message is kept.
+func Reorder(size int, gap time.Duration) Option {
+	return func(p *Protocol) {
+		p.reorder = &reorderer{
+			size: size,
+			gap:  gap,
+			in:   make(chan *portal.Message),
+			buf:  make(map[uint64]*portal.Message),
+			next: 1,
+		}
+	}
+}
+
+type reorderer struct {
+	size int
+	gap  time.Duration
+	in   chan *portal.Message
+
+	buf  map[uint64]*portal.Message
+	next uint64 // sequence number of the next message to deliver
+}
+
+// run forwards messages to rq, the portal's receive queue
+func (r *reorderer) run(ptl portal.ProtocolPortal, rq chan<- *portal.Message) {
+	cq := ptl.CloseChannel()
+
+	defer func() {
+		for _, msg := range r.buf {
+			ptl.Drop(msg, portal.ErrClosed)
+		}
+	}()
+
+	deliver := func(msg *portal.Message) bool {
+		select {
+		case rq <- msg:
+			return true
+		case <-cq:
+			ptl.Drop(msg, portal.ErrClosed)
+			return false
+		}
+	}
+
+	var (
+		timer   *time.Timer
+		expired <-chan time.Time
+		waiting uint64 // the gap being timed
+	)
+
+	for {
+		select {
+		case <-cq:
+			return
+		case msg := <-r.in:
+			seq, ok := msg.Header[portal.HeaderSeq].(uint64)
+			if !ok || seq < r.next {
+				if !deliver(msg) {
+					return
+				}
+				continue
+			}
+
+			if old, ok := r.buf[seq]; ok {
+				ptl.Drop(old, ErrDuplicate)
+			}
+			r.buf[seq] = msg
+		case <-expired:
+			r.skip()
+		}
+
+		if !r.flush(deliver) {
+			return
+		}
+
+		for len(r.buf) > 0 && len(r.buf) >= r.size {
+			r.skip()
+			if !r.flush(deliver) {
+				return
+			}
+		}
+
+		// time the current gap, if any
+		switch {
+		case len(r.buf) == 0 && timer != nil:
+			timer.Stop()
+			timer, expired = nil, nil
+		case len(r.buf) > 0 && (timer == nil || waiting != r.next):
+			if timer != nil {
+				timer.Stop()
+			}
+
+			timer = time.NewTimer(r.gap)
+			expired = timer.C
+			waiting = r.next
+		}
+	}
+}
+
+// flush delivers the buffered messages that are next in sequence.  It returns
+// false if the portal was closed.
+func (r *reorderer) flush(deliver func(*portal.Message) bool) bool {
+	for msg, ok := r.buf[r.next]; ok; msg, ok = r.buf[r.next] {
+		delete(r.buf, r.next)
+		r.next++
+
+		if !deliver(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// skip the gap before the lowest buffered sequence number
+func (r *reorderer) skip() {
+	first := true
+	for seq := range r.buf {
+		if first || seq < r.next {
+			r.next, first = seq, false
+		}
+	}
+}
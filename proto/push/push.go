@@ -1,15 +1,41 @@
 package push
 
 import (
+	"time"
+
 	"github.com/SentimensRG/ctx"
 	"github.com/lthibault/portal"
 	"github.com/lthibault/portal/proto"
 )
 
+type pushEP struct {
+	portal.Endpoint
+	rq chan *portal.Message // requeued messages
+}
+
+// Option configures the PUSH protocol
+type Option func(*Protocol)
+
+// CircuitBreaker excludes PULL peers that repeatedly fail to accept a message
+// within the timeout, or that return messages because they were not
+// acknowledged (see pull.NewAck).  Messages that time out are handed to
+// another peer if possible.  The state of each peer can be inspected through
+// the Breakers.
+func CircuitBreaker(b *proto.Breakers, timeout time.Duration) Option {
+	return func(p *Protocol) {
+		p.breakers = b
+		p.timeout = timeout
+	}
+}
+
 // Protocol implementing PUSH
 type Protocol struct {
 	ptl portal.ProtocolPortal
 	n   proto.Neighborhood
+	sq  <-chan *portal.Message
+
+	breakers *proto.Breakers
+	timeout  time.Duration // for delivery to a peer;  zero if unbounded
 }
 
 // Init the PUSH protocol
@@ -17,25 +43,141 @@ func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 	close(ptl.RecvChannel()) // NOTE : if mysterious error, maybe it's this?
 	p.ptl = ptl
 	p.n = proto.NewNeighborhood()
+	p.sq = ptl.SendChannel()
+}
+
+// SendQueue implements portal.ProtocolSendQueue.  When circuit breaking is
+// enabled, peers may not take messages directly from the portal.
+func (p *Protocol) SendQueue() <-chan *portal.Message {
+	if p.breakers == nil {
+		return nil
+	}
+	return make(chan *portal.Message) // never written
 }
 
-func (p Protocol) startSending(pe portal.Endpoint) {
-	sq := p.ptl.SendChannel()
-	rq := pe.RecvChannel()
+func (p Protocol) startSending(pe *pushEP) {
+	defer p.ptl.Recover(pe)
+
+	sq := p.sq
 	cq := ctx.Link(ctx.Lift(p.ptl.CloseChannel()), pe)
 
 	for {
+		if !p.breakers.Allow(pe.ID()) {
+			select {
+			case <-cq:
+				return
+			case msg := <-pe.rq:
+				p.deliver(pe, msg, cq)
+			case <-time.After(p.breakers.Cooldown()):
+			}
+			continue
+		}
+
 		select {
 		case <-cq:
 			return
+		case msg := <-pe.rq:
+			p.deliver(pe, msg, cq)
 		case msg, ok := <-sq:
 			if !ok {
 				sq = p.ptl.SendChannel()
+			} else if msg.Expired() {
+				p.ptl.Drop(msg, portal.ErrExpired)
 			} else {
-				rq <- msg
+				p.deliver(pe, msg, cq)
+			}
+		}
+	}
+}
+
+// deliver a message to the peer.  If the peer does not accept it before the
+// timeout, the failure is reported to the circuit breaker and the message is
+// handed to another peer.
+func (p Protocol) deliver(pe *pushEP, msg *portal.Message, cq <-chan struct{}) {
+	if p.timeout <= 0 {
+		pe.RecvChannel() <- msg
+		return
+	}
+
+	t := time.NewTimer(p.timeout)
+	defer t.Stop()
+
+	select {
+	case pe.RecvChannel() <- msg:
+		p.breakers.Success(pe.ID())
+		return
+	case <-t.C:
+		p.breakers.Failure(pe.ID())
+	case <-cq:
+		p.ptl.Drop(msg, portal.ErrClosed)
+		return
+	}
+
+	if target := p.alternate(pe.ID()); target != nil {
+		// the target may itself be stuck handing off a message to this peer
+		go func() {
+			if !p.enqueue(target, msg) {
+				p.ptl.Drop(msg, portal.ErrClosed)
 			}
+		}()
+		return
+	}
+
+	select { // nobody else can take it
+	case pe.RecvChannel() <- msg:
+	case <-cq:
+		p.ptl.Drop(msg, portal.ErrClosed)
+	}
+}
+
+// Redeliver a message that was not acknowledged by the PULL portal identified
+// by from.  Another peer is chosen if possible.
+func (p Protocol) Redeliver(msg *portal.Message, from portal.ID) bool {
+	p.breakers.Failure(from)
+
+	target := p.alternate(from)
+	if target == nil {
+		ep, ok := p.n.GetPeer(from)
+		if !ok {
+			return false
+		}
+		target = ep.(*pushEP)
+	}
+
+	return p.enqueue(target, msg)
+}
+
+// alternate returns a peer other than the specified one, preferring peers
+// whose circuit breaker is closed.  It returns nil if there is no other peer.
+func (p Protocol) alternate(id portal.ID) (target *pushEP) {
+	m, done := p.n.RMap()
+	defer done()
+
+	for peerID, ep := range m {
+		if peerID == id {
+			continue
+		}
+
+		target = ep.(*pushEP)
+		if !p.breakers.Tripped(peerID) {
+			break
 		}
 	}
+
+	return
+}
+
+// enqueue a message for delivery by the target's sender.  It returns false if
+// the target or the portal was closed first.
+func (p Protocol) enqueue(target *pushEP, msg *portal.Message) bool {
+	select {
+	case target.rq <- msg:
+		return true
+	case <-target.Done():
+	case <-p.ptl.CloseChannel():
+	}
+
+	return false
 }
 
 func (Protocol) Number() uint16     { return proto.Push }
@@ -45,13 +187,23 @@ func (Protocol) PeerName() string   { return "pull" }
 
 func (p Protocol) AddEndpoint(ep portal.Endpoint) {
 	proto.MustBeCompatible(p, ep.Signature())
-	p.n.SetPeer(ep.ID(), ep)
-	go p.startSending(ep)
+
+	pe := &pushEP{Endpoint: ep, rq: make(chan *portal.Message)}
+	p.n.SetPeer(ep.ID(), pe)
+	go p.startSending(pe)
 }
 
-func (p Protocol) RemoveEndpoint(ep portal.Endpoint) { p.n.DropPeer(ep.ID()) }
+func (p Protocol) RemoveEndpoint(ep portal.Endpoint) {
+	p.n.DropPeer(ep.ID())
+	p.breakers.Remove(ep.ID())
+}
 
 // New allocates a WriteOnly Portal using the PUSH protocol
-func New(cfg portal.Cfg) portal.WriteOnly {
-	return struct{ portal.WriteOnly }{portal.MakePortal(cfg, &Protocol{})} // write guard
+func New(cfg portal.Cfg, opt ...Option) portal.WriteOnly {
+	p := new(Protocol)
+	for _, o := range opt {
+		o(p)
+	}
+
+	return portal.NewWriteGuard(portal.MakePortal(cfg, p))
 }
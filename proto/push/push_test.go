@@ -7,6 +7,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto"
 	"github.com/lthibault/portal/proto/pull"
 )
 
@@ -76,3 +77,155 @@ func TestIntegration(t *testing.T) {
 	}
 
 }
+
+func TestRedelivery(t *testing.T) {
+	pushP := New(portal.Cfg{})
+	defer pushP.Close()
+
+	if err := pushP.Bind("/test/push/redelivery"); err != nil {
+		t.Fatal(err)
+	}
+
+	type delivery struct {
+		n int
+		d *portal.Delivery
+	}
+
+	ch := make(chan delivery)
+	for i := 0; i < 2; i++ {
+		p := pull.NewAck(portal.Cfg{AckTimeout: time.Millisecond * 10})
+		defer p.Close()
+
+		if err := p.Connect("/test/push/redelivery"); err != nil {
+			t.Fatal(err)
+		}
+
+		go func(n int, p pull.AckPortal) {
+			for d := p.Recv(); d != nil; d = p.Recv() {
+				ch <- delivery{n, d}
+			}
+		}(i, p)
+	}
+
+	go pushP.Send(true)
+
+	var first delivery
+	select {
+	case first = <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("value was not delivered")
+	}
+
+	select {
+	case second := <-ch:
+		if second.n == first.n {
+			t.Error("value was redelivered to the same portal")
+		}
+
+		if err := second.d.Ack(); err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("value was not redelivered")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := proto.NewBreakers(proto.BreakerCfg{Failures: 1, Cooldown: time.Second})
+
+	pushP := New(portal.Cfg{Size: 4}, CircuitBreaker(b, time.Millisecond*5))
+	defer pushP.Close()
+
+	if err := pushP.Bind("/test/push/breaker"); err != nil {
+		t.Fatal(err)
+	}
+
+	stuck := pull.New(portal.Cfg{}) // never receives
+	defer stuck.Close()
+	healthy := pull.New(portal.Cfg{})
+	defer healthy.Close()
+
+	for _, p := range []portal.ReadOnly{stuck, healthy} {
+		if err := p.Connect("/test/push/breaker"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ch := portal.RecvChan(healthy)
+	for i := 0; i < 4; i++ {
+		pushP.Send(i)
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Millisecond * 100):
+			t.Fatalf("received %d of 4 values", i)
+		}
+	}
+
+	var open int
+	for _, s := range b.States() {
+		if s.State == proto.BreakerOpen {
+			open++
+		}
+	}
+
+	if open != 1 {
+		t.Errorf("expected 1 open breaker, got %d", open)
+	}
+}
+
+// brokenPull is a PULL peer whose receive queue is closed, so that delivering
+// a message to it panics
+type brokenPull struct{ removed chan portal.ID }
+
+func (brokenPull) Init(portal.ProtocolPortal)  {}
+func (brokenPull) Number() uint16              { return proto.Pull }
+func (brokenPull) PeerNumber() uint16          { return proto.Push }
+func (brokenPull) Name() string                { return "pull" }
+func (brokenPull) PeerName() string            { return "push" }
+func (brokenPull) AddEndpoint(portal.Endpoint) {}
+
+func (b brokenPull) RemoveEndpoint(ep portal.Endpoint) { b.removed <- ep.ID() }
+
+func (brokenPull) RecvQueue() chan<- *portal.Message {
+	ch := make(chan *portal.Message)
+	close(ch)
+	return ch
+}
+
+func TestRecover(t *testing.T) {
+	errs := make(chan error, 1)
+	p := New(portal.Cfg{Size: 1, OnError: func(err error) { errs <- err }})
+	defer p.Close()
+
+	if err := p.Bind("/test/push/recover"); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := brokenPull{removed: make(chan portal.ID, 1)}
+	remote := portal.MakePortal(portal.Cfg{}, peer)
+	defer remote.Close()
+
+	if err := remote.Connect("/test/push/recover"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Send("hello")
+
+	select {
+	case err := <-errs:
+		if _, ok := err.(*portal.PanicError); !ok {
+			t.Errorf("expected *PanicError, got %T", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+
+	select {
+	case <-peer.removed:
+	case <-time.After(time.Second):
+		t.Fatal("remote end of the connection was not removed")
+	}
+}
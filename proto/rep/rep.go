@@ -14,6 +14,9 @@ type Protocol struct {
 	sync.Mutex
 	ptl portal.ProtocolPortal
 	n   proto.Neighborhood
+
+	idLock sync.Mutex
+	reqID  interface{} // proto.HeaderRequestID of the last request received
 }
 
 func (p *Protocol) Init(ptl portal.ProtocolPortal) {
@@ -22,48 +25,67 @@ func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 }
 
 func (p *Protocol) startServing(pe proto.PeerEndpoint) {
-	var msg *portal.Message
-	defer func() {
-		if msg != nil {
-			msg.Free()
-		}
-		if r := recover(); r != nil {
-			msg.Free()
-			panic(r)
+	defer p.ptl.Recover(pe)
+
+	sq := p.ptl.SendChannel()
+	for msg := pe.Announce(); msg != nil; msg = pe.Announce() {
+		if !p.serve(pe, msg, &sq) {
+			return
 		}
-	}()
+	}
+}
+
+// serve a request.  The lock is held until the reply is sent, so that it goes
+// to the peer that made the request.  It returns false if the portal was
+// closed.
+func (p *Protocol) serve(pe proto.PeerEndpoint, msg *portal.Message, sq *<-chan *portal.Message) bool {
+	p.Lock()
+	defer p.Unlock()
 
 	cq := p.ptl.CloseChannel()
-	rq := p.ptl.RecvChannel()
-	sq := p.ptl.SendChannel()
 
-	for msg = pe.Announce(); msg != nil; msg = pe.Announce() {
-		p.Lock()
+	select {
+	case <-cq:
+		p.ptl.Drop(msg, portal.ErrClosed)
+		return false
+	case p.ptl.RecvChannel() <- msg: // the application now owns msg
+	}
 
-		select {
-		case <-cq:
-			p.Unlock()
-			return
-		case rq <- msg:
-			select {
-			case <-cq:
-				p.Unlock()
-				return
-			case <-pe.Done():
-				msg.Free()
-				p.Unlock()
-				continue
-			case msg = <-sq:
-				if msg == nil {
-					sq = p.ptl.SendChannel()
-					p.Unlock()
-				} else {
-					pe.Notify(msg)
-					p.Unlock()
-				}
-			}
+	select {
+	case <-cq:
+		return false
+	case <-pe.Done():
+	case rep := <-*sq:
+		if rep == nil {
+			*sq = p.ptl.SendChannel()
+		} else {
+			pe.Notify(rep)
 		}
 	}
+
+	return true
+}
+
+// RecvHook records the ID of the request, if any
+func (p *Protocol) RecvHook(msg *portal.Message) bool {
+	if msg != nil {
+		p.idLock.Lock()
+		p.reqID = msg.Header[proto.HeaderRequestID]
+		p.idLock.Unlock()
+	}
+
+	return true
+}
+
+// SendHook tags the reply with the ID of the request it answers
+func (p *Protocol) SendHook(msg *portal.Message) bool {
+	p.idLock.Lock()
+	if p.reqID != nil {
+		msg.Annotate(proto.HeaderRequestID, p.reqID)
+	}
+	p.idLock.Unlock()
+
+	return true
 }
 
 func (*Protocol) Number() uint16     { return proto.Rep }
@@ -0,0 +1,37 @@
+package rep
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/req"
+)
+
+func TestCloseDuringRequest(t *testing.T) {
+	errs := make(chan error, 1)
+	p := New(portal.Cfg{OnError: func(err error) { errs <- err }})
+	if err := p.Bind("/test/rep/close"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := req.New(portal.Cfg{Size: 1})
+	defer r.Close()
+
+	if err := r.Connect("/test/rep/close"); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Send("hello")
+	if v := p.Recv(); v != "hello" {
+		t.Fatalf("expected request, got %v", v)
+	}
+
+	p.Close() // before replying
+
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error %s", err)
+	case <-time.After(time.Millisecond * 20):
+	}
+}
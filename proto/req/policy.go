@@ -0,0 +1,440 @@
+package req
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lthibault/portal"
+	proto "github.com/lthibault/portal/proto"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTimeout is the reason given when a request is dropped because every
+	// attempt to send it timed out.  The request is passed to Drop, so it is
+	// counted in Stats and routed to Cfg.DeadLetter.
+	ErrTimeout = errors.New("request timed out")
+
+	// ErrCanceled is the reason given when a request or reply is dropped
+	// because another attempt succeeded first.
+	ErrCanceled = errors.New("request canceled")
+)
+
+const (
+	minSamples = 8  // latencies needed before the hedging delay is computed
+	maxSamples = 64 // latencies used to compute the hedging delay
+)
+
+// Option configures the REQ protocol.  When any option is set, each request is
+// tagged with proto.HeaderRequestID and dispatched to a peer by the protocol,
+// and replies to abandoned attempts are discarded.
+type Option func(*Protocol)
+
+// Timeout abandons an attempt if no reply arrives within d.  The request is
+// then retried, subject to Attempts.  Without a timeout, a request that cannot
+// be sent because there are no peers waits for one to join.
+func Timeout(d time.Duration) Option {
+	return func(p *Protocol) { p.policy().timeout = d }
+}
+
+// Attempts sets the maximum number of times a request is sent, including the
+// first.  It defaults to 1.
+func Attempts(n int) Option {
+	return func(p *Protocol) { p.policy().attempts = n }
+}
+
+// Backoff waits before retrying a request.  The delay starts at min and doubles
+// after each attempt, up to max.
+func Backoff(min, max time.Duration) Option {
+	return func(p *Protocol) {
+		c := p.policy()
+		c.backoff, c.maxBackoff = min, max
+	}
+}
+
+// Hedge sends a request to a second peer if the first has not replied within
+// the specified percentile (0-100) of recent reply latencies.  The first reply
+// to arrive is returned, and the other attempt is canceled.  Until enough
+// replies have been observed, the fallback delay is used.
+func Hedge(percentile float64, fallback time.Duration) Option {
+	return func(p *Protocol) {
+		c := p.policy()
+		c.hedge, c.hedgeDelay = percentile, fallback
+	}
+}
+
+// CircuitBreaker excludes REP peers whose attempts repeatedly time out.  It
+// requires a Timeout.  The state of each peer can be inspected through the
+// Breakers.
+func CircuitBreaker(b *proto.Breakers) Option {
+	return func(p *Protocol) { p.policy().breakers = b }
+}
+
+func (p *Protocol) policy() *client {
+	if p.c == nil {
+		p.c = &client{attempts: 1}
+	}
+	return p.c
+}
+
+type eventKind uint8
+
+const (
+	evHedge eventKind = iota
+	evTimeout
+	evRetry
+)
+
+type event struct {
+	id      uint64
+	attempt int
+	kind    eventKind
+}
+
+// call is an outstanding request
+type call struct {
+	id       uint64
+	value    interface{}
+	header   portal.Header
+	priority int
+	deadline time.Time
+
+	attempt int
+	sent    time.Time // start of the current attempt
+	hedged  bool
+	waiting bool // backing off before the next attempt
+	idle    bool // waiting for a peer to join
+	tried   map[portal.ID]bool
+	peers   []portal.ID   // sent the current attempt
+	cancel  chan struct{} // closed when the current attempt is abandoned
+	timers  []*time.Timer
+}
+
+func (cl *call) message() *portal.Message {
+	msg := portal.NewDetachedMsg()
+	msg.Value = cl.value
+	msg.Priority = cl.priority
+	msg.Deadline = cl.deadline
+	for k, v := range cl.header {
+		msg.Annotate(k, v)
+	}
+	msg.Annotate(proto.HeaderRequestID, cl.id)
+	return msg
+}
+
+func (cl *call) abort() {
+	if cl.cancel != nil {
+		close(cl.cancel)
+		cl.cancel = nil
+	}
+}
+
+// client dispatches requests according to the configured policy
+type client struct {
+	timeout             time.Duration
+	attempts            int
+	backoff, maxBackoff time.Duration
+	hedge               float64
+	hedgeDelay          time.Duration
+	breakers            *proto.Breakers
+
+	ptl     portal.ProtocolPortal
+	n       proto.Neighborhood
+	sq      <-chan *portal.Message
+	rq      chan<- *portal.Message
+	peerQ   chan *portal.Message // handed to peers in place of sq;  never written
+	replies chan *portal.Message
+	events  chan event
+	joined  chan struct{} // signaled when a peer is added
+
+	calls  map[uint64]*call
+	lastID uint64
+	lat    []time.Duration // most recent reply latencies
+}
+
+func (c *client) init(ptl portal.ProtocolPortal, n proto.Neighborhood) {
+	c.ptl = ptl
+	c.n = n
+	c.sq = ptl.SendChannel()
+	c.rq = ptl.RecvChannel()
+	c.peerQ = make(chan *portal.Message)
+	c.replies = make(chan *portal.Message)
+	c.events = make(chan event)
+	c.joined = make(chan struct{}, 1)
+	c.calls = make(map[uint64]*call)
+}
+
+func (c *client) run() {
+	cq := c.ptl.CloseChannel()
+
+	defer func() {
+		for _, cl := range c.calls {
+			c.finish(cl)
+		}
+	}()
+
+	for {
+		select {
+		case <-cq:
+			return
+		case msg := <-c.sq:
+			c.start(msg)
+		case msg := <-c.replies:
+			if !c.reply(msg) {
+				return
+			}
+		case ev := <-c.events:
+			c.handle(ev)
+		case <-c.joined:
+			for _, cl := range c.calls {
+				if cl.idle {
+					c.dispatch(cl)
+				}
+			}
+		}
+	}
+}
+
+// start a call.  The sender is released as soon as the request is dispatched;
+// from then on, the call ends with a reply, or is dropped with ErrTimeout.
+func (c *client) start(msg *portal.Message) {
+	if msg.Expired() {
+		c.ptl.Drop(msg, portal.ErrExpired)
+		return
+	}
+
+	c.lastID++
+	cl := &call{
+		id:       c.lastID,
+		value:    msg.Value,
+		priority: msg.Priority,
+		deadline: msg.Deadline,
+		tried:    make(map[portal.ID]bool),
+	}
+
+	for k, v := range msg.Header {
+		if cl.header == nil {
+			cl.header = make(portal.Header, len(msg.Header))
+		}
+		cl.header[k] = v
+	}
+
+	msg.Free()
+
+	c.calls[cl.id] = cl
+	c.attempt(cl)
+}
+
+func (c *client) attempt(cl *call) {
+	cl.attempt++
+	cl.hedged = false
+	cl.waiting = false
+	cl.peers = cl.peers[:0]
+	cl.cancel = make(chan struct{})
+
+	if c.timeout > 0 {
+		c.after(c.timeout, cl, evTimeout)
+	}
+
+	c.dispatch(cl)
+}
+
+// dispatch the current attempt.  If there are no peers, the call is left idle
+// until one joins or the attempt times out.
+func (c *client) dispatch(cl *call) {
+	if cl.idle = !c.send(cl, false); cl.idle {
+		return
+	}
+
+	cl.sent = time.Now()
+	if c.hedge > 0 {
+		c.after(c.delay(), cl, evHedge)
+	}
+}
+
+// join wakes up idle calls once a peer has been added
+func (c *client) join() {
+	select {
+	case c.joined <- struct{}{}:
+	default:
+	}
+}
+
+// send the request to a peer, preferring one that was not tried yet.  If fresh
+// is true, only untried peers are considered.  Peers whose circuit breaker is
+// open are skipped.  It returns false if there was no suitable peer.
+func (c *client) send(cl *call, fresh bool) bool {
+	var untried, tried []portal.Endpoint
+
+	m, done := c.n.RMap()
+	for id, ep := range m {
+		if cl.tried[id] {
+			tried = append(tried, ep)
+		} else {
+			untried = append(untried, ep)
+		}
+	}
+	done()
+
+	if !fresh {
+		untried = append(untried, tried...)
+	}
+
+	var pe portal.Endpoint
+	for _, ep := range untried {
+		if c.breakers.Allow(ep.ID()) {
+			pe = ep
+			break
+		}
+	}
+
+	if pe == nil {
+		return false
+	}
+
+	cl.tried[pe.ID()] = true
+	cl.peers = append(cl.peers, pe.ID())
+
+	msg := cl.message()
+	cancel := cl.cancel
+	go func() {
+		select {
+		case pe.RecvChannel() <- msg:
+		case <-pe.Done():
+			c.ptl.Drop(msg, portal.ErrClosed)
+		case <-cancel:
+			c.ptl.Drop(msg, ErrCanceled)
+		}
+	}()
+
+	return true
+}
+
+func (c *client) after(d time.Duration, cl *call, kind eventKind) {
+	ev := event{id: cl.id, attempt: cl.attempt, kind: kind}
+	cq := c.ptl.CloseChannel()
+
+	cl.timers = append(cl.timers, time.AfterFunc(d, func() {
+		select {
+		case c.events <- ev:
+		case <-cq:
+		}
+	}))
+}
+
+// handle a timer event
+func (c *client) handle(ev event) {
+	cl, ok := c.calls[ev.id]
+	if !ok || ev.attempt != cl.attempt {
+		return // stale
+	}
+
+	switch {
+	case ev.kind == evRetry:
+		c.attempt(cl)
+	case cl.waiting:
+		// the attempt was already abandoned
+	case ev.kind == evHedge:
+		if !cl.hedged && !cl.idle {
+			cl.hedged = c.send(cl, true)
+		}
+	case ev.kind == evTimeout:
+		c.fail(cl)
+	}
+}
+
+// fail abandons the current attempt, then either schedules a retry or drops the
+// request with ErrTimeout.
+func (c *client) fail(cl *call) {
+	cl.abort()
+	cl.idle = false
+	for _, id := range cl.peers {
+		c.breakers.Failure(id)
+	}
+
+	if cl.attempt < c.attempts {
+		cl.waiting = true
+		c.after(c.backoffFor(cl.attempt), cl, evRetry)
+		return
+	}
+
+	c.finish(cl)
+	c.ptl.Drop(cl.message(), ErrTimeout)
+}
+
+// reply handles a message from a peer.  It returns false if the portal was
+// closed.
+func (c *client) reply(msg *portal.Message) bool {
+	if id, ok := msg.Header[proto.HeaderRequestID].(uint64); ok {
+		cl, ok := c.calls[id]
+		if !ok {
+			c.ptl.Drop(msg, ErrCanceled) // another attempt won
+			return true
+		}
+
+		if msg.From != nil {
+			c.breakers.Success(*msg.From)
+		}
+
+		c.record(time.Since(cl.sent))
+		c.finish(cl)
+	}
+
+	return c.forward(msg)
+}
+
+func (c *client) forward(msg *portal.Message) bool {
+	select {
+	case c.rq <- msg:
+		return true
+	case <-c.ptl.CloseChannel():
+		c.ptl.Drop(msg, portal.ErrClosed)
+		return false
+	}
+}
+
+func (c *client) finish(cl *call) {
+	cl.abort()
+	for _, t := range cl.timers {
+		t.Stop()
+	}
+
+	delete(c.calls, cl.id)
+}
+
+func (c *client) backoffFor(attempt int) time.Duration {
+	d := c.backoff
+	for i := 1; i < attempt && (c.maxBackoff <= 0 || d < c.maxBackoff); i++ {
+		d *= 2
+	}
+
+	if c.maxBackoff > 0 && d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+
+	return d
+}
+
+func (c *client) record(d time.Duration) {
+	if len(c.lat) == maxSamples {
+		c.lat = append(c.lat[:0], c.lat[1:]...)
+	}
+	c.lat = append(c.lat, d)
+}
+
+// delay before hedging a request
+func (c *client) delay() time.Duration {
+	if len(c.lat) < minSamples {
+		return c.hedgeDelay
+	}
+
+	sorted := append([]time.Duration(nil), c.lat...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(c.hedge / 100 * float64(len(sorted)-1))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return sorted[i]
+}
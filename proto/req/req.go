@@ -9,11 +9,35 @@ import (
 type Protocol struct {
 	ptl portal.ProtocolPortal
 	n   proto.Neighborhood
+	c   *client // nil unless an Option is set
 }
 
 func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 	p.ptl = ptl
 	p.n = proto.NewNeighborhood()
+
+	if p.c != nil {
+		p.c.init(ptl, p.n)
+		go p.c.run()
+	}
+}
+
+// SendQueue implements portal.ProtocolSendQueue.  When a policy is set, peers
+// may not take requests directly from the portal.
+func (p *Protocol) SendQueue() <-chan *portal.Message {
+	if p.c == nil {
+		return nil
+	}
+	return p.c.peerQ
+}
+
+// RecvQueue implements portal.ProtocolRecvQueue.  When a policy is set,
+// replies are matched with outstanding requests before they are delivered.
+func (p *Protocol) RecvQueue() chan<- *portal.Message {
+	if p.c == nil {
+		return nil
+	}
+	return p.c.replies
 }
 
 func (p Protocol) startSending(pe proto.PeerEndpoint) {
@@ -22,6 +46,8 @@ func (p Protocol) startSending(pe proto.PeerEndpoint) {
 	// added, we can reasonably safely cache the channels -- they won't
 	// be changing after this point.
 
+	defer p.ptl.Recover(pe)
+
 	sq := p.ptl.SendChannel()
 	cq := p.ptl.CloseChannel()
 
@@ -35,29 +61,34 @@ func (p Protocol) startSending(pe proto.PeerEndpoint) {
 			return
 		}
 
+		if msg.Expired() {
+			p.ptl.Drop(msg, portal.ErrExpired)
+			continue
+		}
+
 		pe.Notify(msg)
 	}
 }
 
-func (p Protocol) startReceiving(ep portal.Endpoint) {
+func (p Protocol) startReceiving(pe proto.PeerEndpoint) {
 	var msg *portal.Message
+	defer p.ptl.Recover(pe)
 	defer func() {
 		if msg != nil {
 			msg.Free()
 		}
-		if r := recover(); r != nil {
-			msg.Free()
-			panic(r)
-		}
 	}()
 
 	rq := p.ptl.RecvChannel()
 	cq := p.ptl.CloseChannel()
 
-	for msg = ep.Announce(); msg != nil; msg = ep.Announce() {
+	id := pe.ID()
+	for msg = pe.Announce(); msg != nil; msg = pe.Announce() {
+		msg.From = &id
+
 		select {
 		case <-cq:
-			break
+			return
 		case rq <- msg:
 		}
 	}
@@ -75,13 +106,27 @@ func (p Protocol) AddEndpoint(ep portal.Endpoint) {
 
 	p.n.SetPeer(ep.ID(), pe)
 
-	go p.startSending(pe)
-	go p.startReceiving(ep)
+	if p.c == nil {
+		go p.startSending(pe) // otherwise, requests are dispatched by the client
+	} else {
+		p.c.join()
+	}
+	go p.startReceiving(pe)
 }
 
-func (p Protocol) RemoveEndpoint(ep portal.Endpoint) { p.n.DropPeer(ep.ID()) }
+func (p Protocol) RemoveEndpoint(ep portal.Endpoint) {
+	p.n.DropPeer(ep.ID())
+	if p.c != nil {
+		p.c.breakers.Remove(ep.ID())
+	}
+}
 
 // New allocates a Portal using the REQ protocol
-func New(cfg portal.Cfg) portal.Portal {
-	return portal.MakePortal(cfg, &Protocol{})
+func New(cfg portal.Cfg, opt ...Option) portal.Portal {
+	p := new(Protocol)
+	for _, o := range opt {
+		o(p)
+	}
+
+	return portal.MakePortal(cfg, p)
 }
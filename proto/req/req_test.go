@@ -0,0 +1,267 @@
+package req
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lthibault/portal"
+	proto "github.com/lthibault/portal/proto"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/rep"
+)
+
+// serve replies to each request after the specified delay.  Requests are
+// ignored if the delay is negative.
+func serve(t *testing.T, addr string, delay time.Duration) portal.Portal {
+	p := rep.New(portal.Cfg{})
+	if err := p.Bind(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer func() { recover() }() // p may be closed while a reply is delayed
+
+		for v := range p.All(nil) {
+			if delay < 0 {
+				continue
+			}
+
+			time.Sleep(delay)
+			p.Send(v)
+		}
+	}()
+
+	return p
+}
+
+func recv(t *testing.T, p portal.Portal, timeout time.Duration) interface{} {
+	ch := make(chan interface{}, 1)
+	go func() { ch <- p.Recv() }()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(timeout):
+		t.Fatal("no reply")
+		return nil
+	}
+}
+
+func TestHedge(t *testing.T) {
+	slow := serve(t, "/test/req/hedge/slow", time.Millisecond*200)
+	defer slow.Close()
+	fast := serve(t, "/test/req/hedge/fast", 0)
+	defer fast.Close()
+
+	p := New(portal.Cfg{}, Hedge(95, time.Millisecond*10))
+	defer p.Close()
+
+	for _, addr := range []string{"/test/req/hedge/slow", "/test/req/hedge/fast"} {
+		if err := p.Connect(addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ { // at least one request is sent to the slow peer first
+		p.Send(i)
+		if v := recv(t, p, time.Millisecond*100); v != i {
+			t.Errorf("expected %d, got %v", i, v)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	t.Run("Retry", func(t *testing.T) {
+		mute := serve(t, "/test/req/retry/mute", -1)
+		defer mute.Close()
+		ok := serve(t, "/test/req/retry/ok", 0)
+		defer ok.Close()
+
+		p := New(portal.Cfg{}, Timeout(time.Millisecond*10), Attempts(2), Backoff(time.Millisecond, time.Millisecond))
+		defer p.Close()
+
+		for _, addr := range []string{"/test/req/retry/mute", "/test/req/retry/ok"} {
+			if err := p.Connect(addr); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p.Send("hello")
+		if v := recv(t, p, time.Millisecond*100); v != "hello" {
+			t.Errorf("expected reply, got %v", v)
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		mute := serve(t, "/test/req/timeout/mute", -1)
+		defer mute.Close()
+
+		dlq := pull.New(portal.Cfg{Size: 1})
+		defer dlq.Close()
+
+		if err := dlq.Bind("/test/req/timeout/dlq"); err != nil {
+			t.Fatal(err)
+		}
+
+		p := New(portal.Cfg{DeadLetter: "/test/req/timeout/dlq"}, Timeout(time.Millisecond*5), Attempts(3))
+		defer p.Close()
+
+		if err := p.Connect("/test/req/timeout/mute"); err != nil {
+			t.Fatal(err)
+		}
+
+		p.Send("hello")
+
+		ch := make(chan portal.Header, 1)
+		go func() {
+			v, h := dlq.RecvHeader()
+			if v != "hello" {
+				t.Errorf("expected the request to be dropped, got %v", v)
+			}
+			ch <- h
+		}()
+
+		select {
+		case h := <-ch:
+			if r := h[portal.HeaderDropReason]; r != ErrTimeout.Error() {
+				t.Errorf("expected ErrTimeout, got %v", r)
+			}
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("request was not dropped")
+		}
+	})
+
+	t.Run("NoPeers", func(t *testing.T) {
+		p := New(portal.Cfg{}, Attempts(2))
+		defer p.Close()
+
+		if err := p.Bind("/test/req/nopeers"); err != nil {
+			t.Fatal(err)
+		}
+
+		p.Send("hello") // held until a peer joins
+
+		r := rep.New(portal.Cfg{})
+		defer r.Close()
+
+		if err := r.Connect("/test/req/nopeers"); err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			defer func() { recover() }() // r may be closed first
+
+			if v, ok := r.RecvOK(); ok {
+				r.Send(v)
+			}
+		}()
+
+		if v := recv(t, p, time.Millisecond*100); v != "hello" {
+			t.Errorf("expected reply, got %v", v)
+		}
+	})
+
+	t.Run("CircuitBreaker", func(t *testing.T) {
+		mute := serve(t, "/test/req/breaker/mute", -1)
+		defer mute.Close()
+		ok := serve(t, "/test/req/breaker/ok", 0)
+		defer ok.Close()
+
+		b := proto.NewBreakers(proto.BreakerCfg{Failures: 1, Cooldown: time.Second})
+		p := New(portal.Cfg{}, Timeout(time.Millisecond*10), Attempts(2), CircuitBreaker(b))
+		defer p.Close()
+
+		for _, addr := range []string{"/test/req/breaker/mute", "/test/req/breaker/ok"} {
+			if err := p.Connect(addr); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var timeouts int
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			p.Send(i)
+			if v := recv(t, p, time.Millisecond*100); v != i {
+				t.Errorf("expected %d, got %v", i, v)
+			}
+
+			if time.Since(start) >= time.Millisecond*10 {
+				timeouts++
+			}
+		}
+
+		if timeouts > 1 { // the mute peer trips on its first timeout
+			t.Error("request was sent to a tripped peer")
+		}
+
+		states := b.States()
+		if len(states) != 2 {
+			t.Fatalf("expected 2 peers, got %d", len(states))
+		}
+
+		var open int
+		for _, s := range states {
+			if s.State == proto.BreakerOpen {
+				open++
+			}
+		}
+
+		if open > 1 {
+			t.Errorf("expected at most 1 open breaker, got %d", open)
+		}
+	})
+}
+
+// brokenRep is a REP peer whose receive queue is closed, so that delivering a
+// request to it panics
+type brokenRep struct{ removed chan portal.ID }
+
+func (brokenRep) Init(portal.ProtocolPortal)  {}
+func (brokenRep) Number() uint16              { return proto.Rep }
+func (brokenRep) PeerNumber() uint16          { return proto.Req }
+func (brokenRep) Name() string                { return "rep" }
+func (brokenRep) PeerName() string            { return "req" }
+func (brokenRep) AddEndpoint(portal.Endpoint) {}
+
+func (b brokenRep) RemoveEndpoint(ep portal.Endpoint) { b.removed <- ep.ID() }
+
+func (brokenRep) RecvQueue() chan<- *portal.Message {
+	ch := make(chan *portal.Message)
+	close(ch)
+	return ch
+}
+
+func TestRecover(t *testing.T) {
+	errs := make(chan error, 1)
+	p := New(portal.Cfg{Size: 1, OnError: func(err error) { errs <- err }})
+	defer p.Close()
+
+	if err := p.Bind("/test/req/recover"); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := brokenRep{removed: make(chan portal.ID, 1)}
+	remote := portal.MakePortal(portal.Cfg{}, peer)
+	defer remote.Close()
+
+	if err := remote.Connect("/test/req/recover"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Send("hello")
+
+	select {
+	case err := <-errs:
+		if _, ok := err.(*portal.PanicError); !ok {
+			t.Errorf("expected *PanicError, got %T", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+
+	select {
+	case <-peer.removed:
+	case <-time.After(time.Second):
+		t.Fatal("remote end of the connection was not removed")
+	}
+}
@@ -1,8 +1,6 @@
 package star
 
 import (
-	"sync"
-
 	"github.com/SentimensRG/ctx"
 	"github.com/lthibault/portal"
 	"github.com/lthibault/portal/proto"
@@ -27,7 +25,7 @@ func (s starEP) sendMsg(msg *portal.Message) {
 	select {
 	case s.q <- msg:
 	case <-s.Done():
-		msg.Free()
+		s.star.ptl.Drop(msg, portal.ErrClosed)
 	}
 }
 
@@ -35,10 +33,15 @@ func (s starEP) startSending() {
 	rq := s.RecvChannel()
 	cq := s.Done()
 	for msg := range s.q {
+		if msg.Expired() {
+			s.star.ptl.Drop(msg, portal.ErrExpired)
+			continue
+		}
+
 		select {
 		case rq <- msg:
 		case <-cq:
-			msg.Free()
+			s.star.ptl.Drop(msg, portal.ErrClosed)
 			return
 		}
 	}
@@ -48,8 +51,8 @@ func (s starEP) startReceiving() {
 	rq := s.star.ptl.RecvChannel()
 	cq := ctx.Link(ctx.Lift(s.star.ptl.CloseChannel()), s)
 
+	id := s.ID()
 	for msg := range s.SendChannel() {
-		id := s.ID()
 		msg.From = &id
 		select {
 		case <-cq:
@@ -74,8 +77,6 @@ func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 }
 
 func (p Protocol) startSending() {
-	var wg sync.WaitGroup
-
 	sq := p.ptl.SendChannel()
 	cq := p.ptl.CloseChannel()
 
@@ -91,43 +92,34 @@ func (p Protocol) startSending() {
 				panic("ensure portal.Doner fires closes before chSend/chRecv")
 			}
 
-			p.broadcast(&wg, msg).Wait()
+			p.broadcast(msg)
 		}
 	}
 }
 
-func (p Protocol) broadcast(wg *sync.WaitGroup, msg *portal.Message) (wgout *sync.WaitGroup) {
+func (p Protocol) broadcast(msg *portal.Message) {
 	m, done := p.n.RMap() // get a read-locked map-view of the Neighborhood
 	defer done()
 
 	for id, peer := range m {
 		// if there's a header, it means the msg was rebroadcast
-		if id == *msg.From {
+		if msg.From != nil && id == *msg.From {
 			continue
 		}
 
 		// proto.Neighborhood stores portal.Endpoints, so we must type-assert
-		go p.unicast(wg, peer.(msgSender), msg)
+		peer.(msgSender).sendMsg(msg.Share())
 	}
 
 	if msg.From != nil { // Grab a local copy and send it up
 		select {
 		case <-p.ptl.CloseChannel():
 			msg.Free()
-			return wg
 		case p.ptl.RecvChannel() <- msg:
 		}
 	} else { // Not sending the message up, so let's release it
 		msg.Free()
 	}
-
-	return wg
-}
-
-func (p Protocol) unicast(wg *sync.WaitGroup, s msgSender, msg *portal.Message) {
-	wg.Add(1)
-	s.sendMsg(msg.Ref())
-	wg.Done()
 }
 
 func (p *Protocol) AddEndpoint(ep portal.Endpoint) {
@@ -83,22 +83,9 @@ func (p *Protocol) Init(ptl portal.ProtocolPortal) {
 	p.subs = &subscription{t: make([]Topic, 0)}
 }
 
-func (p Protocol) startReceiving(ep portal.Endpoint) {
-	rq := p.ptl.RecvChannel()
-	cq := p.ptl.CloseChannel()
-
-	for msg := range ep.SendChannel() {
-		if p.subs.Match(msg.Value) {
-			select {
-			case rq <- msg:
-			case <-cq:
-				return
-			}
-		} else {
-			msg.Free()
-		}
-	}
-}
+// Filter discards values that do not match a subscribed topic.  PUB peers
+// deliver each message directly to the SUB portal.
+func (p Protocol) Filter(v interface{}) bool { return p.subs.Match(v) }
 
 func (Protocol) Number() uint16     { return proto.Sub }
 func (Protocol) PeerNumber() uint16 { return proto.Pub }
@@ -108,7 +95,6 @@ func (Protocol) PeerName() string   { return "pub" }
 func (Protocol) RemoveEndpoint(portal.Endpoint) {}
 func (p Protocol) AddEndpoint(ep portal.Endpoint) {
 	proto.MustBeCompatible(p, ep.Signature())
-	go p.startReceiving(ep)
 }
 
 func (p Protocol) Subscribe(t Topic) error { return p.subs.Subscribe(t) }
@@ -121,14 +107,16 @@ type Portal interface {
 	Unsubscribe(Topic)
 }
 
+type subPortal struct {
+	portal.ReadGuard
+	*Protocol
+}
+
 // New allocates a portal using the SUB protocol
 func New(cfg portal.Cfg) Portal {
 	s := &Protocol{}
-	return struct {
-		portal.ReadOnly
-		*Protocol
-	}{
-		ReadOnly: portal.MakePortal(cfg, s),
-		Protocol: s,
+	return subPortal{
+		ReadGuard: portal.NewReadGuard(portal.MakePortal(cfg, s)),
+		Protocol:  s,
 	}
 }
@@ -0,0 +1,106 @@
+package portal
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message reference counts can be audited by building with the portaldebug
+// tag:
+//
+//	go test -tags portaldebug ./...
+//
+// In debug builds, every call to NewMsg, Ref and Free records the caller's
+// stack.  A Free that would drop the reference count below zero panics with a
+// *DoubleFreeError describing both the offending call and the Free that
+// released the message.  Freed messages are never recycled, so that a stale
+// handle cannot release a message that was reallocated.  Messages that remain
+// referenced for longer than the leak timeout are reported to OnLeak.  In
+// regular builds, these facilities are compiled out and Leaks always returns
+// nil.
+
+// DefaultLeakTimeout is the age beyond which a message that is still
+// referenced is considered to have leaked, unless SetLeakTimeout is called.
+const DefaultLeakTimeout = time.Second * 30
+
+var leakTimeout = struct {
+	sync.RWMutex
+	d time.Duration
+}{d: DefaultLeakTimeout}
+
+// SetLeakTimeout sets the age beyond which a message that is still referenced
+// is considered to have leaked.  It is only used by debug builds.
+func SetLeakTimeout(d time.Duration) {
+	leakTimeout.Lock()
+	leakTimeout.d = d
+	leakTimeout.Unlock()
+}
+
+func getLeakTimeout() time.Duration {
+	leakTimeout.RLock()
+	defer leakTimeout.RUnlock()
+	return leakTimeout.d
+}
+
+// OnLeak is called once for each leaked message.  It is only used by debug
+// builds, and defaults to logging the report.
+var OnLeak = func(r LeakReport) { logLeak(r) }
+
+// RefEvent records a call to NewMsg, Ref or Free
+type RefEvent struct {
+	Op    string // "new", "ref" or "free"
+	Stack string
+}
+
+// LeakReport describes a message that is still referenced after the leak
+// timeout
+type LeakReport struct {
+	Value  interface{}
+	Age    time.Duration
+	Refs   int        // outstanding references
+	Events []RefEvent // in chronological order
+}
+
+func (r LeakReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "portal: message (%T) leaked with %d outstanding reference(s) after %s",
+		r.Value, r.Refs, r.Age)
+
+	for _, e := range r.Events {
+		fmt.Fprintf(&b, "\n\n%s:\n%s", e.Op, e.Stack)
+	}
+
+	return b.String()
+}
+
+// DoubleFreeError is the panic value raised by debug builds when a message is
+// freed more times than it was referenced.
+type DoubleFreeError struct {
+	Value                interface{}
+	LastFree, DoubleFree RefEvent
+}
+
+func (e *DoubleFreeError) Error() string {
+	return fmt.Sprintf("portal: message (%T) freed twice\n\nreleased by:\n%s\nfreed again by:\n%s",
+		e.Value, e.LastFree.Stack, e.DoubleFree.Stack)
+}
+
+// callers returns a formatted stack trace, skipping the portal internals
+func callers(skip int) string {
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(skip+2, pc)])
+
+	var b strings.Builder
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,20 @@
+//go:build !portaldebug
+// +build !portaldebug
+
+package portal
+
+import "log"
+
+type refLog struct{}
+
+func (m *Message) debugNew()  {}
+func (m *Message) debugRef()  {}
+func (m *Message) debugFree() {}
+
+func recyclable(*Message) bool { return true }
+
+// Leaks returns reports for all messages that have leaked.  It always returns
+// nil unless the portaldebug build tag is set.
+func Leaks() []LeakReport { return nil }
+
+func logLeak(r LeakReport) { log.Println(r) }
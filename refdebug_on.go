@@ -0,0 +1,130 @@
+//go:build portaldebug
+// +build portaldebug
+
+package portal
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type refLog struct {
+	sync.Mutex
+	refs     int
+	born     time.Time
+	reported bool
+	events   []RefEvent
+}
+
+// live tracks messages that have been allocated by NewMsg and not yet
+// released.
+var live = struct {
+	sync.Mutex
+	msgs map[*Message]struct{}
+}{msgs: make(map[*Message]struct{})}
+
+func init() { go reapLeaks() }
+
+func reapLeaks() {
+	for {
+		time.Sleep(getLeakTimeout() / 2)
+		for _, r := range leaks(true) {
+			OnLeak(r)
+		}
+	}
+}
+
+// Leaks returns reports for all messages that have leaked
+func Leaks() []LeakReport { return leaks(false) }
+
+func leaks(markReported bool) (rs []LeakReport) {
+	live.Lock()
+	defer live.Unlock()
+
+	now, timeout := time.Now(), getLeakTimeout()
+	for m := range live.msgs {
+		l := m.refs
+
+		l.Lock()
+		if age := now.Sub(l.born); age >= timeout && !(markReported && l.reported) {
+			rs = append(rs, LeakReport{
+				Value:  m.Value,
+				Age:    age,
+				Refs:   l.refs,
+				Events: append([]RefEvent(nil), l.events...),
+			})
+			l.reported = l.reported || markReported
+		}
+		l.Unlock()
+	}
+
+	return
+}
+
+func (m *Message) debugNew() {
+	if m.refs == nil {
+		m.refs = new(refLog)
+	}
+
+	m.refs.Lock()
+	m.refs.refs = 1
+	m.refs.born = time.Now()
+	m.refs.reported = false
+	m.refs.events = append(m.refs.events[:0], RefEvent{Op: "new", Stack: callers(2)})
+	m.refs.Unlock()
+
+	live.Lock()
+	live.msgs[m] = struct{}{}
+	live.Unlock()
+}
+
+func (m *Message) debugRef() {
+	if m.refs == nil {
+		return // not allocated by NewMsg
+	}
+
+	m.refs.Lock()
+	m.refs.refs++
+	m.refs.events = append(m.refs.events, RefEvent{Op: "ref", Stack: callers(2)})
+	m.refs.Unlock()
+}
+
+func (m *Message) debugFree() {
+	if m.refs == nil {
+		return // not allocated by NewMsg
+	}
+
+	ev := RefEvent{Op: "free", Stack: callers(2)}
+
+	m.refs.Lock()
+	if m.refs.refs == 0 {
+		err := &DoubleFreeError{Value: m.Value, DoubleFree: ev}
+		for i := len(m.refs.events) - 1; i >= 0; i-- {
+			if m.refs.events[i].Op == "free" {
+				err.LastFree = m.refs.events[i]
+				break
+			}
+		}
+		m.refs.Unlock()
+		panic(err)
+	}
+
+	m.refs.refs--
+	m.refs.events = append(m.refs.events, ev)
+	released := m.refs.refs == 0
+	m.refs.Unlock()
+
+	if released {
+		live.Lock()
+		delete(live.msgs, m)
+		live.Unlock()
+	}
+}
+
+// recyclable returns false, so that freed messages are poisoned rather than
+// returned to the pool.  A stale handle then panics with a *DoubleFreeError
+// instead of releasing the message's next owner.
+func recyclable(*Message) bool { return false }
+
+func logLeak(r LeakReport) { log.Println(r) }
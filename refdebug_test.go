@@ -0,0 +1,83 @@
+//go:build portaldebug
+// +build portaldebug
+
+package portal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDoubleFree(t *testing.T) {
+	msg := NewMsg()
+	msg.Value = "double free"
+	msg.Free()
+
+	defer func() {
+		err, ok := recover().(*DoubleFreeError)
+		if !ok {
+			t.Fatal("expected *DoubleFreeError")
+		}
+
+		if !strings.Contains(err.LastFree.Stack, "TestDoubleFree") {
+			t.Errorf("stack of previous Free does not include caller:\n%s", err.LastFree.Stack)
+		}
+
+		if !strings.Contains(err.DoubleFree.Stack, "TestDoubleFree") {
+			t.Errorf("stack of double Free does not include caller:\n%s", err.DoubleFree.Stack)
+		}
+	}()
+
+	msg.Free()
+}
+
+func TestNoRecycle(t *testing.T) {
+	freed := make(map[*Message]struct{})
+	for i := 0; i < 100; i++ {
+		msg := NewMsg()
+		if _, ok := freed[msg]; ok {
+			t.Fatal("freed message was reallocated")
+		}
+
+		msg.Free()
+		freed[msg] = struct{}{}
+	}
+}
+
+func TestLeaks(t *testing.T) {
+	defer SetLeakTimeout(getLeakTimeout())
+	SetLeakTimeout(0)
+
+	leaked := NewMsg()
+	leaked.Value = "leaked"
+	leaked.Ref()
+	leaked.Free()
+
+	freed := NewMsg()
+	freed.Value = "freed"
+	freed.Free()
+
+	var rs []LeakReport
+	for _, r := range Leaks() {
+		if s, ok := r.Value.(string); ok && (s == "leaked" || s == "freed") {
+			rs = append(rs, r)
+		}
+	}
+
+	if len(rs) != 1 {
+		t.Fatalf("expected 1 leak, got %d", len(rs))
+	}
+
+	if rs[0].Refs != 1 {
+		t.Errorf("expected 1 outstanding ref, got %d", rs[0].Refs)
+	}
+
+	var ops []string
+	for _, e := range rs[0].Events {
+		ops = append(ops, e.Op)
+	}
+
+	if s := strings.Join(ops, ","); s != "new,ref,free" {
+		t.Errorf("unexpected events %s", s)
+	}
+}
@@ -0,0 +1,197 @@
+package portal
+
+import (
+	"reflect"
+	"sync/atomic"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/pkg/errors"
+)
+
+// Case is a case in a call to Select.  Exactly one of Recv and Send should be
+// set.
+type Case struct {
+	Recv  ReadOnly
+	Send  WriteOnly
+	Value interface{} // sent by Send cases
+}
+
+// offer is the message prepared for a Send case
+type offer struct {
+	v     interface{} // value handed to the protocol
+	msg   *Message
+	f     *future
+	wait  bool // the case completes once the message is delivered
+	fresh bool // the message was assigned a new sequence number
+}
+
+// offer prepares the message for a Send case.  It returns false if the value
+// will not be sent, either because the portal was closed or because the value
+// was dropped.
+func (p *portal) offer(v interface{}) (o offer, ok bool) {
+	if _, ok := v.(Sequenced); !ok {
+		o.fresh = p.Sequence
+	}
+
+	if o.msg, o.f, o.wait = p.outgoing(v, 0, nil, nil); o.msg == nil {
+		return
+	}
+
+	if !p.prepare(o.msg) {
+		if o.wait {
+			p.await(o.msg, o.f)
+		}
+		return
+	}
+
+	o.v = o.msg.Value
+	return o, true
+}
+
+// withdraw an offer that was not chosen.  Its rate-limit token and, if no other
+// value was sent in the meantime, its sequence number are returned, and the
+// message is released without being counted as dropped.
+func (p *portal) withdraw(o offer) {
+	if p.limit != nil {
+		p.limit.cancel()
+	}
+
+	if o.fresh {
+		seq := o.msg.Header[HeaderSeq].(uint64)
+		atomic.CompareAndSwapUint64(&p.nextSeq, seq, seq-1)
+	}
+
+	o.msg.Free()
+	if o.wait {
+		p.await(o.msg, o.f)
+	}
+}
+
+// delivery returns a channel that is ready once a message sent by a sync Send
+// case was delivered
+func (o offer) delivery() interface{} {
+	if o.f != nil {
+		return o.f.done
+	}
+	return o.msg.sig
+}
+
+// Select waits until one of the cases can proceed, then performs it.  It is the
+// portal equivalent of a select statement.
+//
+// Select returns the index of the case that proceeded and, for Recv cases,
+// the value received.  If ok is false, the chosen portal was closed.  If d
+// expires first, Select returns -1.  A nil d never expires.
+//
+// A Recv case only takes a message from its portal if the case is chosen.  A
+// Send case is ready once its portal accepts the value, as Send would.  The
+// value offered by each Send case is prepared before Select waits, so send
+// hooks and middleware see the values of every Send case;  values of cases that
+// are not chosen are discarded without being sent.  Unless the chosen portal is
+// buffered, Select then waits for the value to be delivered, or for d to
+// expire.
+func Select(d ctx.Doner, cases ...Case) (chosen int, v interface{}, ok bool) {
+	n := len(cases)
+	ptls := make([]*portal, n)
+	offers := make([]offer, n)
+
+	// sc[i] is the i-th case's channel, and sc[n+i] its Done channel.  sc[2*n]
+	// is d, and sc[2*n+1] the delivery of the chosen value.
+	sc := make([]reflect.SelectCase, 2*n+2)
+
+	for i, c := range cases {
+		switch {
+		case c.Recv != nil:
+			ptls[i] = coreOf(c.Recv)
+			if !ptls[i].ready.Load() {
+				panic(errors.New("recv from disconnected portal"))
+			}
+
+			sc[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ptls[i].chRecv)}
+		case c.Send != nil:
+			ptls[i] = coreOf(c.Send)
+			if !ptls[i].ready.Load() {
+				panic(errors.New("send to disconnected portal"))
+			}
+		default:
+			panic(errors.Errorf("case %d has neither Recv nor Send", i))
+		}
+
+		sc[n+i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ptls[i].Done())}
+	}
+
+	defer func() {
+		for i, o := range offers {
+			if i != chosen && o.msg != nil {
+				ptls[i].withdraw(o)
+			}
+		}
+	}()
+
+	for i, c := range cases {
+		if c.Send == nil {
+			continue
+		}
+
+		o, sent := ptls[i].offer(c.Value)
+		if !sent {
+			return i, nil, o.msg != nil || ptls[i].ready.Load()
+		}
+
+		offers[i] = o
+		sc[i] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ptls[i].chSend), Send: reflect.ValueOf(o.msg)}
+	}
+
+	sc[2*n].Dir, sc[2*n+1].Dir = reflect.SelectRecv, reflect.SelectRecv
+	if d != nil {
+		sc[2*n].Chan = reflect.ValueOf(d.Done())
+	}
+
+	sending := -1 // index of the Send case awaiting delivery
+	for {
+		i, rv, recvOK := reflect.Select(sc)
+		switch {
+		case i == 2*n+1: // the chosen value was delivered
+			if o := offers[sending]; o.f == nil {
+				msgPool.Put(o.msg)
+			}
+			return sending, nil, true
+		case i == 2*n && sending >= 0: // d expired before the value was delivered
+			o := offers[sending]
+			go ptls[sending].await(o.msg, o.f)
+			return sending, nil, true
+		case i == 2*n: // d expired
+			return -1, nil, false
+		case i >= n: // portal closed
+			return i - n, nil, false
+		case cases[i].Send != nil:
+			o := offers[i]
+			ptls[i].track(o.v)
+			ptls[i].stats.Sent(o.v)
+
+			if !o.wait {
+				return i, nil, true
+			}
+
+			// wait for delivery, without letting other cases proceed
+			sending = i
+			for j := 0; j < 2*n; j++ {
+				sc[j].Chan = reflect.Value{}
+			}
+			sc[2*n+1].Chan = reflect.ValueOf(o.delivery())
+			continue
+		case !recvOK: // receive channel closed by the protocol
+			return i, nil, false
+		}
+
+		msg, accepted := ptls[i].accept(rv.Interface().(*Message))
+		if !accepted {
+			continue
+		}
+
+		if v, ok = ptls[i].decodeMsg(msg); ok {
+			ptls[i].consume(msg)
+			return i, v, true
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package portal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+)
+
+func TestSelect(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	mkPortal := func() *portal {
+		ptl, _ := mkSendRecvTestPortal(p, 1)
+		ptl.setRunning()
+		return ptl
+	}
+
+	t.Run("Recv", func(t *testing.T) {
+		p0, p1 := mkPortal(), mkPortal()
+		defer p0.Close()
+		defer p1.Close()
+
+		msg := NewMsg()
+		msg.Value = "hello"
+		msg.detach()
+		p1.chRecv <- msg
+
+		i, v, ok := Select(nil, Case{Recv: p0}, Case{Recv: p1})
+		if i != 1 || v != "hello" || !ok {
+			t.Errorf("unexpected result (%d, %v, %t)", i, v, ok)
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		p0, p1 := mkPortal(), mkPortal()
+		defer p0.Close()
+		defer p1.Close()
+
+		i, _, ok := Select(nil, Case{Recv: p0}, Case{Send: p1, Value: "hello"})
+		if i != 1 || !ok {
+			t.Errorf("unexpected result (%d, %t)", i, ok)
+		}
+
+		msg := <-p1.chSend
+		defer msg.Free()
+
+		if msg.Value != "hello" {
+			t.Errorf("unexpected value %v", msg.Value)
+		}
+	})
+
+	t.Run("Unsent", func(t *testing.T) {
+		p0, p1 := mkPortal(), mkPortal()
+		defer p0.Close()
+		defer p1.Close()
+
+		p1.chSend <- NewMsg() // fill the send buffer
+
+		msg := NewMsg()
+		msg.Value = "hello"
+		msg.detach()
+		p0.chRecv <- msg
+
+		if i, _, _ := Select(nil, Case{Recv: p0}, Case{Send: p1, Value: "dropped"}); i != 0 {
+			t.Errorf("expected case 0, got %d", i)
+		}
+
+		(<-p1.chSend).Free()
+
+		select {
+		case msg := <-p1.chSend:
+			t.Errorf("unexpected value %v was sent", msg.Value)
+		case <-time.After(time.Millisecond * 10):
+		}
+	})
+
+	t.Run("NoReader", func(t *testing.T) {
+		p0, _ := mkSendRecvTestPortal(p, 0)
+		p0.setRunning()
+		defer p0.Close()
+
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		time.AfterFunc(time.Millisecond, cancel)
+
+		ch := make(chan int, 1)
+		go func() {
+			i, _, _ := Select(d, Case{Send: p0, Value: "hello"})
+			ch <- i
+		}()
+
+		select {
+		case i := <-ch:
+			if i != -1 {
+				t.Errorf("expected -1, got %d", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Select ignored the deadline")
+		}
+	})
+
+	t.Run("Undelivered", func(t *testing.T) {
+		p0, _ := mkSendRecvTestPortal(p, 0)
+		p0.setRunning()
+		defer p0.Close()
+
+		held := make(chan *Message, 1)
+		go func() { held <- <-p0.chSend }() // never delivered
+
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		time.AfterFunc(time.Millisecond*10, cancel)
+
+		ch := make(chan int, 1)
+		go func() {
+			i, _, _ := Select(d, Case{Send: p0, Value: "hello"})
+			ch <- i
+		}()
+
+		select {
+		case i := <-ch:
+			if i != 0 {
+				t.Errorf("expected case 0, got %d", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Select ignored the deadline")
+		}
+
+		(<-held).Free()
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		p0 := mkPortal()
+		defer p0.Close()
+
+		d, cancel := ctx.WithCancel(ctx.Lift(make(chan struct{})))
+		time.AfterFunc(time.Millisecond, cancel)
+
+		if i, _, ok := Select(d, Case{Recv: p0}); i != -1 || ok {
+			t.Errorf("unexpected result (%d, %t)", i, ok)
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		p0 := mkPortal()
+		time.AfterFunc(time.Millisecond, p0.Close)
+
+		if i, _, ok := Select(nil, Case{Recv: p0}); i != 0 || ok {
+			t.Errorf("unexpected result (%d, %t)", i, ok)
+		}
+	})
+}
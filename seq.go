@@ -0,0 +1,38 @@
+package portal
+
+import "sync/atomic"
+
+// HeaderSeq holds the sequence number of a message sent by a portal with
+// Cfg.Sequence set.
+const HeaderSeq = "portal.seq" // uint64
+
+// Sequenced is a value paired with its sequence number.  Portals with
+// Cfg.Sequence set return Sequenced values from Recv, and send Sequenced values
+// with their existing sequence number.  This allows a worker to pass the
+// sequence number of each input on to its result:
+//
+//	s := in.Recv().(portal.Sequenced)
+//	out.Send(portal.Sequenced{Seq: s.Seq, Value: process(s.Value)})
+type Sequenced struct {
+	Seq   uint64
+	Value interface{}
+}
+
+// sequence returns the value to send, along with its sequence number.  Fresh
+// values are numbered from 1.
+func (p *portal) sequence(v interface{}) (interface{}, uint64) {
+	if s, ok := v.(Sequenced); ok {
+		return s.Value, s.Seq
+	}
+
+	return v, atomic.AddUint64(&p.nextSeq, 1)
+}
+
+// sequenced wraps a received value with the message's sequence number, if any
+func sequenced(msg *Message, v interface{}) interface{} {
+	if seq, ok := msg.Header[HeaderSeq].(uint64); ok {
+		return Sequenced{Seq: seq, Value: v}
+	}
+
+	return v
+}
@@ -0,0 +1,31 @@
+package portal
+
+import "testing"
+
+func TestSequence(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(*Message) bool { return true },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	ptl, _ := mkSendRecvTestPortal(p, 3)
+	ptl.Sequence = true
+	ptl.setRunning()
+	defer ptl.Close()
+
+	ptl.Send("a")
+	ptl.Send("b")
+	ptl.Send(Sequenced{Seq: 7, Value: "c"})
+
+	for _, expect := range []Sequenced{{1, "a"}, {2, "b"}, {7, "c"}} {
+		msg := <-ptl.chSend
+		if msg.Value != expect.Value || msg.Header[HeaderSeq] != expect.Seq {
+			t.Errorf("expected %v, got %v with header %v", expect, msg.Value, msg.Header)
+		}
+
+		ptl.chRecv <- msg // loop back
+		if v := ptl.Recv(); v != expect {
+			t.Errorf("expected %v, got %v", expect, v)
+		}
+	}
+}
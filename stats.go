@@ -0,0 +1,131 @@
+package portal
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets used by latency histograms
+var LatencyBuckets = []time.Duration{
+	time.Microsecond * 10,
+	time.Microsecond * 100,
+	time.Millisecond,
+	time.Millisecond * 10,
+	time.Millisecond * 100,
+	time.Second,
+	time.Second * 10,
+}
+
+// Histogram is a point-in-time view of a latency distribution
+type Histogram struct {
+	Count uint64
+	Sum   time.Duration
+
+	// Bounds and Counts are parallel slices.  Counts[i] is the cumulative
+	// number of observations that were less than or equal to Bounds[i].
+	Bounds []time.Duration
+	Counts []uint64
+}
+
+// Stats is a snapshot of a portal's activity
+type Stats struct {
+	Sent, Received, Dropped  uint64
+	Expired                  uint64 // messages dropped because they expired
+	DeadLetterOverflow       uint64 // dead letters discarded because the dead-letter portal was full
+	BytesSent, BytesReceived uint64 // only []byte payloads are counted
+
+	SendQueue, RecvQueue int // number of messages waiting in the portal
+	Peers                int
+
+	SendLatency, RecvLatency Histogram
+}
+
+type histogram struct {
+	count, sum uint64
+	buckets    []uint64 // non-cumulative; the last bucket is +Inf
+}
+
+func newHistogram() histogram {
+	return histogram{buckets: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+func (h *histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+
+	atomic.AddUint64(&h.buckets[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+func (h *histogram) Snapshot() Histogram {
+	s := Histogram{
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadUint64(&h.sum)),
+		Bounds: LatencyBuckets,
+		Counts: make([]uint64, len(LatencyBuckets)),
+	}
+
+	var n uint64
+	for i := range s.Counts {
+		n += atomic.LoadUint64(&h.buckets[i])
+		s.Counts[i] = n
+	}
+
+	return s
+}
+
+// counters are allocated separately from the portal to guarantee 64-bit
+// alignment of the atomically-accessed fields.
+type counters struct {
+	sent, recvd, dropped uint64
+	expired, overflow    uint64
+	bytesSent, bytesRecv uint64
+	peers                int64
+
+	sendLatency, recvLatency histogram
+}
+
+func newCounters() *counters {
+	return &counters{
+		sendLatency: newHistogram(),
+		recvLatency: newHistogram(),
+	}
+}
+
+func (c *counters) Sent(v interface{}) {
+	atomic.AddUint64(&c.sent, 1)
+	if b, ok := v.([]byte); ok {
+		atomic.AddUint64(&c.bytesSent, uint64(len(b)))
+	}
+}
+
+func (c *counters) Recvd(v interface{}) {
+	atomic.AddUint64(&c.recvd, 1)
+	if b, ok := v.([]byte); ok {
+		atomic.AddUint64(&c.bytesRecv, uint64(len(b)))
+	}
+}
+
+func (c *counters) Dropped()     { atomic.AddUint64(&c.dropped, 1) }
+func (c *counters) Expired()     { atomic.AddUint64(&c.expired, 1) }
+func (c *counters) Overflowed()  { atomic.AddUint64(&c.overflow, 1) }
+func (c *counters) PeerAdded()   { atomic.AddInt64(&c.peers, 1) }
+func (c *counters) PeerRemoved() { atomic.AddInt64(&c.peers, -1) }
+func (c *counters) Peers() int   { return int(atomic.LoadInt64(&c.peers)) }
+
+func (c *counters) Stats() (s Stats) {
+	s.Sent = atomic.LoadUint64(&c.sent)
+	s.Received = atomic.LoadUint64(&c.recvd)
+	s.Dropped = atomic.LoadUint64(&c.dropped)
+	s.Expired = atomic.LoadUint64(&c.expired)
+	s.DeadLetterOverflow = atomic.LoadUint64(&c.overflow)
+	s.BytesSent = atomic.LoadUint64(&c.bytesSent)
+	s.BytesReceived = atomic.LoadUint64(&c.bytesRecv)
+	s.Peers = c.Peers()
+	s.SendLatency = c.sendLatency.Snapshot()
+	s.RecvLatency = c.recvLatency.Snapshot()
+	return
+}
@@ -0,0 +1,120 @@
+package portal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	h.Observe(time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(time.Minute)
+
+	s := h.Snapshot()
+	if s.Count != 3 {
+		t.Errorf("expected count=3, got %d", s.Count)
+	}
+
+	if s.Sum != time.Microsecond+time.Millisecond+time.Minute {
+		t.Errorf("unexpected sum %s", s.Sum)
+	}
+
+	if n := s.Counts[0]; n != 1 {
+		t.Errorf("expected 1 observation in first bucket, got %d", n)
+	}
+
+	if n := s.Counts[len(s.Counts)-1]; n != 2 {
+		t.Errorf("expected 2 observations in last bucket (cumulative), got %d", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	p := mockProtoExt{
+		onSend: func(msg *Message) bool { return msg.Value != nil },
+		onRecv: func(*Message) bool { return true },
+	}
+
+	ptl, _ := mkSendRecvTestPortal(p, 2)
+	defer ptl.Close()
+
+	t.Run("Send", func(t *testing.T) {
+		msg := NewMsg()
+		msg.Value = []byte("hello")
+		ptl.SendMsg(msg)
+
+		drop := NewMsg()
+		ptl.SendMsg(drop)
+
+		s := ptl.Stats()
+		if s.Sent != 1 {
+			t.Errorf("expected sent=1, got %d", s.Sent)
+		}
+
+		if s.BytesSent != 5 {
+			t.Errorf("expected bytesSent=5, got %d", s.BytesSent)
+		}
+
+		if s.Dropped != 1 {
+			t.Errorf("expected dropped=1, got %d", s.Dropped)
+		}
+
+		if s.SendQueue != 1 {
+			t.Errorf("expected send queue depth of 1, got %d", s.SendQueue)
+		}
+
+		(<-ptl.chSend).Free()
+	})
+
+	t.Run("Recv", func(t *testing.T) {
+		ptl.setRunning()
+
+		msg := NewMsg()
+		msg.Value = []byte("world!")
+		ptl.chRecv <- msg
+
+		if s := ptl.Stats(); s.RecvQueue != 1 {
+			t.Errorf("expected recv queue depth of 1, got %d", s.RecvQueue)
+		}
+
+		_ = ptl.Recv()
+
+		s := ptl.Stats()
+		if s.Received != 1 {
+			t.Errorf("expected received=1, got %d", s.Received)
+		}
+
+		if s.BytesReceived != 6 {
+			t.Errorf("expected bytesReceived=6, got %d", s.BytesReceived)
+		}
+
+		if s.RecvLatency.Count != 1 {
+			t.Errorf("expected one latency observation, got %d", s.RecvLatency.Count)
+		}
+	})
+
+	t.Run("Peers", func(t *testing.T) {
+		ptl, cancel := mkSendRecvTestPortal(mockProto{
+			epAdded:   make(chan Endpoint, 1),
+			epRemoved: make(chan Endpoint, 1),
+		}, 0)
+
+		ptl.ConnectEndpoint(mockEP{id: NewID()})
+		if n := ptl.Stats().Peers; n != 1 {
+			t.Errorf("expected 1 peer, got %d", n)
+		}
+
+		cancel()
+		<-ptl.proto.(mockProto).epRemoved
+
+		deadline := time.After(time.Millisecond * 100)
+		for ptl.Stats().Peers != 0 {
+			select {
+			case <-deadline:
+				t.Fatalf("expected 0 peers, got %d", ptl.Stats().Peers)
+			default:
+				time.Sleep(time.Microsecond * 10)
+			}
+		}
+	})
+}
@@ -0,0 +1,378 @@
+// Package supervisor runs services that communicate through portals, restarting
+// them when they crash.
+//
+// Each service declares the portals it uses, and the addresses to which they
+// are bound or connected.  The supervisor creates the portals, and starts the
+// services in dependency order:  a service that connects to an address is
+// started after the service that binds it.  Since closing a bound portal may
+// close the portals connected to it, the services that depend on a crashed
+// service are restarted along with it, which re-establishes their connections.
+package supervisor
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/SentimensRG/ctx/sigctx"
+	"github.com/lthibault/portal"
+	"github.com/pkg/errors"
+)
+
+// Strategy determines which services are restarted when one crashes
+type Strategy uint8
+
+const (
+	// OneForOne restarts the service that crashed, and the services that
+	// depend on it
+	OneForOne Strategy = iota
+
+	// OneForAll stops every service when one crashes, then restarts them all
+	OneForAll
+)
+
+// Defaults for Cfg
+const (
+	DefaultMinBackoff = time.Millisecond * 10
+	DefaultMaxBackoff = time.Second * 10
+)
+
+// Cfg configures a Supervisor
+type Cfg struct {
+	// Services are stopped when the Doner expires
+	ctx.Doner
+
+	Strategy Strategy
+
+	// A crashed service is restarted after a delay, which starts at
+	// MinBackoff and doubles with each consecutive crash, up to MaxBackoff.
+	// A service that runs for longer than MaxBackoff is considered healthy
+	// again.
+	MinBackoff, MaxBackoff time.Duration
+
+	// OnError is called when a service crashes, or cannot be started.  By
+	// default, the error is logged.
+	OnError func(service string, err error)
+}
+
+// Port declares a portal used by a service
+type Port struct {
+	Addr string
+	Bind bool // if false, the portal connects to the address
+
+	// New allocates the portal, e.g.:
+	//
+	//	func(cfg portal.Cfg) portal.Transporter { return push.New(cfg) }
+	New func(portal.Cfg) portal.Transporter
+}
+
+// Spec declares a supervised service
+type Spec struct {
+	Name  string
+	Ports []Port
+
+	// Serve runs the service until d expires.  The portals are passed in the
+	// order in which they were declared, and are closed when d expires.  If
+	// Serve returns an error or panics, the service is restarted.  If it
+	// returns nil, the service is considered complete.
+	Serve func(d ctx.Doner, ports []portal.Transporter) error
+}
+
+type service struct {
+	Spec
+	deps []int // services binding the addresses to which this one connects
+
+	gen     int // incremented whenever the service is started or stopped
+	running bool
+	cancel  func()
+	ports   []portal.Transporter
+
+	started  time.Time
+	failures int // consecutive
+}
+
+type exit struct {
+	svc, gen int
+	err      error
+}
+
+// Supervisor runs a set of services
+type Supervisor struct {
+	ctx.Doner
+	cancel func()
+
+	cfg      Cfg
+	services []*service
+	order    []int // dependency order
+
+	exits    chan exit
+	restarts chan []int // service indexes, in dependency order
+}
+
+// Start the services.  An error is returned if the specs are inconsistent,
+// e.g. if two services bind the same address, or if their dependencies form a
+// cycle.  Services that cannot be started are retried with backoff.
+func Start(cfg Cfg, specs ...Spec) (*Supervisor, error) {
+	if cfg.Doner == nil {
+		cfg.Doner = sigctx.New()
+	}
+
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+
+	if cfg.OnError == nil {
+		cfg.OnError = func(name string, err error) { log.Printf("supervisor: %s: %s", name, err) }
+	}
+
+	s := &Supervisor{
+		cfg:      cfg,
+		exits:    make(chan exit),
+		restarts: make(chan []int),
+	}
+
+	for _, spec := range specs {
+		s.services = append(s.services, &service{Spec: spec})
+	}
+
+	if err := s.resolve(); err != nil {
+		return nil, err
+	}
+
+	s.Doner, s.cancel = ctx.WithCancel(cfg.Doner)
+
+	for _, i := range s.order {
+		s.start(i)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Close stops the services
+func (s *Supervisor) Close() { s.cancel() }
+
+// resolve the dependencies between services, and sort them accordingly
+func (s *Supervisor) resolve() error {
+	binders := make(map[string]int)
+	for i, svc := range s.services {
+		for _, p := range svc.Ports {
+			if !p.Bind {
+				continue
+			}
+
+			if j, ok := binders[p.Addr]; ok {
+				return errors.Errorf("%s and %s both bind %s", s.services[j].Name, svc.Name, p.Addr)
+			}
+			binders[p.Addr] = i
+		}
+	}
+
+	for i, svc := range s.services {
+		for _, p := range svc.Ports {
+			if j, ok := binders[p.Addr]; ok && !p.Bind && j != i {
+				svc.deps = append(svc.deps, j)
+			}
+		}
+	}
+
+	// depth-first topological sort, preserving declaration order where
+	// possible
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(s.services))
+	var visit func(int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return errors.Errorf("dependency cycle involving %s", s.services[i].Name)
+		case visited:
+			return nil
+		}
+
+		state[i] = visiting
+		for _, j := range s.services[i].deps {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+
+		s.order = append(s.order, i)
+		return nil
+	}
+
+	for i := range s.services {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Supervisor) run() {
+	defer func() {
+		for _, svc := range s.services {
+			s.stop(svc)
+		}
+	}()
+
+	for {
+		select {
+		case <-s.Done():
+			return
+		case e := <-s.exits:
+			svc := s.services[e.svc]
+			if e.gen != svc.gen {
+				continue // the service was already stopped
+			}
+
+			s.stop(svc)
+			if e.err != nil {
+				s.crashed(e.svc, e.err)
+			}
+		case is := <-s.restarts:
+			for _, i := range is {
+				s.start(i)
+			}
+		}
+	}
+}
+
+// start a service, binding and connecting its portals.  A service is not
+// started until the services on which it depends are running;  it is started
+// along with them instead.
+func (s *Supervisor) start(i int) {
+	svc := s.services[i]
+	if svc.running {
+		return
+	}
+
+	for _, j := range svc.deps {
+		if !s.services[j].running {
+			return
+		}
+	}
+
+	svc.gen++
+	svc.started = time.Now()
+
+	d, cancel := ctx.WithCancel(s)
+	cfg := portal.Cfg{Doner: d}
+
+	svc.ports = make([]portal.Transporter, len(svc.Ports))
+	for j, p := range svc.Ports {
+		svc.ports[j] = p.New(cfg)
+
+		var err error
+		if p.Bind {
+			err = svc.ports[j].Bind(p.Addr)
+		} else {
+			err = svc.ports[j].Connect(p.Addr)
+		}
+
+		if err != nil {
+			cancel()
+			s.crashed(i, err)
+			return
+		}
+	}
+
+	svc.running = true
+	svc.cancel = cancel
+
+	go s.serve(i, svc.gen, d, svc.ports)
+}
+
+// dependents returns the service and those that depend on it, directly or
+// indirectly, in dependency order
+func (s *Supervisor) dependents(i int) []int {
+	affected := map[int]bool{i: true}
+	is := make([]int, 0, len(s.services))
+
+	for _, j := range s.order {
+		for _, k := range s.services[j].deps {
+			if affected[k] {
+				affected[j] = true
+			}
+		}
+
+		if affected[j] {
+			is = append(is, j)
+		}
+	}
+
+	return is
+}
+
+func (s *Supervisor) serve(i, gen int, d ctx.Doner, ports []portal.Transporter) {
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Errorf("panic: %s", fmt.Sprint(r))
+			}
+		}()
+
+		return s.services[i].Serve(d, ports)
+	}()
+
+	select {
+	case s.exits <- exit{svc: i, gen: gen, err: err}:
+	case <-s.Done():
+	}
+}
+
+// stop a running service, closing its portals
+func (s *Supervisor) stop(svc *service) {
+	if svc.running {
+		svc.gen++
+		svc.running = false
+		svc.cancel()
+	}
+}
+
+// crashed schedules the restart of a service, according to the strategy
+func (s *Supervisor) crashed(i int, err error) {
+	svc := s.services[i]
+	s.cfg.OnError(svc.Name, err)
+
+	if time.Since(svc.started) > s.cfg.MaxBackoff {
+		svc.failures = 0
+	}
+
+	delay := s.cfg.MinBackoff << uint(svc.failures)
+	if delay > s.cfg.MaxBackoff || delay <= 0 {
+		delay = s.cfg.MaxBackoff
+	}
+	svc.failures++
+
+	is := s.order
+	if s.cfg.Strategy == OneForOne {
+		is = s.dependents(i)
+	}
+
+	for _, j := range is {
+		s.stop(s.services[j])
+	}
+
+	time.AfterFunc(delay, func() {
+		select {
+		case s.restarts <- is:
+		case <-s.Done():
+		}
+	})
+}
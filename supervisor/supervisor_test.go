@@ -0,0 +1,164 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SentimensRG/ctx"
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+	"github.com/pkg/errors"
+)
+
+func pusher(cfg portal.Cfg) portal.Transporter { return push.New(cfg) }
+func puller(cfg portal.Cfg) portal.Transporter { return pull.New(cfg) }
+
+// producer sends n values, then crashes if crash is true
+func producer(addr string, n int, crash bool) Spec {
+	return Spec{
+		Name:  "producer",
+		Ports: []Port{{Addr: addr, Bind: true, New: pusher}},
+		Serve: func(d ctx.Doner, ports []portal.Transporter) error {
+			for i := 0; i < n; i++ {
+				ports[0].(portal.WriteOnly).Send(i)
+			}
+
+			if crash {
+				return errors.New("crash")
+			}
+
+			<-d.Done()
+			return nil
+		},
+	}
+}
+
+// consumer forwards the values it receives
+func consumer(addr string, ch chan<- interface{}) Spec {
+	return Spec{
+		Name:  "consumer",
+		Ports: []Port{{Addr: addr, New: puller}},
+		Serve: func(d ctx.Doner, ports []portal.Transporter) error {
+			for v := range ports[0].(portal.ReadOnly).All(d) {
+				select {
+				case ch <- v:
+				case <-d.Done():
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func recvN(t *testing.T, ch <-chan interface{}, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d values", i, n)
+		}
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		ch := make(chan interface{})
+
+		// the consumer is declared first, but must start after the producer
+		s, err := Start(Cfg{OnError: func(name string, err error) {
+			t.Errorf("%s: %s", name, err)
+		}},
+			consumer("/test/supervisor/order", ch),
+			producer("/test/supervisor/order", 3, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		recvN(t, ch, 3)
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		ch := make(chan interface{})
+		crashes := make(chan string, 16)
+
+		s, err := Start(Cfg{
+			MinBackoff: time.Millisecond,
+			OnError:    func(name string, err error) { crashes <- name },
+		},
+			producer("/test/supervisor/restart", 1, true),
+			consumer("/test/supervisor/restart", ch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		// values keep arriving because the consumer is reconnected to each
+		// new instance of the producer
+		recvN(t, ch, 3)
+
+		if name := <-crashes; name != "producer" {
+			t.Errorf("expected producer to crash, got %s", name)
+		}
+	})
+
+	t.Run("OneForAll", func(t *testing.T) {
+		starts := make(chan struct{}, 16)
+		crashed := false
+
+		s, err := Start(Cfg{
+			Strategy:   OneForAll,
+			MinBackoff: time.Millisecond,
+			OnError:    func(string, error) {},
+		},
+			Spec{
+				Name: "crasher",
+				Serve: func(ctx.Doner, []portal.Transporter) error {
+					if !crashed {
+						crashed = true
+						panic("crash")
+					}
+					return nil
+				},
+			},
+			Spec{
+				Name: "bystander",
+				Serve: func(d ctx.Doner, _ []portal.Transporter) error {
+					starts <- struct{}{}
+					<-d.Done()
+					return nil
+				},
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-starts:
+			case <-time.After(time.Second):
+				t.Fatalf("bystander started %d times", i)
+			}
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		spec := func(name, bind, connect string) Spec {
+			return Spec{
+				Name: name,
+				Ports: []Port{
+					{Addr: bind, Bind: true, New: pusher},
+					{Addr: connect, New: puller},
+				},
+			}
+		}
+
+		if _, err := Start(Cfg{},
+			spec("a", "/test/supervisor/a", "/test/supervisor/b"),
+			spec("b", "/test/supervisor/b", "/test/supervisor/a")); err == nil {
+			t.Error("expected dependency cycle error")
+		}
+	})
+}
@@ -0,0 +1,182 @@
+// Package tracing propagates OpenTelemetry span contexts through portals.
+//
+// Values sent with Send carry the caller's span context across the portal, in
+// the message header.  Receivers recover it with Recv (or Extract), and can
+// pass it on to the next hop so that push/pull pipelines and req/rep exchanges
+// appear as a single, connected trace.
+package tracing
+
+import (
+	"context"
+
+	"github.com/lthibault/portal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/lthibault/portal/tracing"
+
+// HeaderSpanContext holds the span context of the message's producer span.  Its
+// value is opaque, and must be read with Extract.
+const HeaderSpanContext = "tracing.span-context"
+
+// headerContext carries the caller's context from Send to the SendHook, which
+// removes it.
+const headerContext = "tracing.context"
+
+type spanContext struct {
+	prop    propagation.TextMapPropagator
+	carrier propagation.MapCarrier
+}
+
+// Option configures a Hook
+type Option func(*Hook)
+
+// WithTracerProvider sets the TracerProvider used to create spans.  Defaults
+// to the global TracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(h *Hook) { h.tracer = tp.Tracer(instrumentationName) }
+}
+
+// WithPropagator sets the propagator used to encode span contexts into
+// messages.  Defaults to W3C Trace Context.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(h *Hook) { h.prop = p }
+}
+
+// Hook records a span for each message that passes through a portal, and
+// propagates span contexts from senders to receivers.  It implements
+// portal.ProtocolSendHook and portal.ProtocolRecvHook.
+type Hook struct {
+	name   string
+	tracer trace.Tracer
+	prop   propagation.TextMapPropagator
+}
+
+// NewHook allocates a Hook
+func NewHook(opt ...Option) *Hook {
+	h := &Hook{
+		name:   "portal",
+		tracer: otel.GetTracerProvider().Tracer(instrumentationName),
+		prop:   propagation.TraceContext{},
+	}
+
+	for _, fn := range opt {
+		fn(h)
+	}
+
+	return h
+}
+
+// SendHook starts a producer span as a child of the sender's context, and
+// records the span context in the message header.
+func (h *Hook) SendHook(msg *portal.Message) bool {
+	ctx, ok := msg.Header[headerContext].(context.Context)
+	if ok {
+		delete(msg.Header, headerContext)
+	} else {
+		ctx = context.Background()
+	}
+
+	ctx, span := h.tracer.Start(ctx, h.name+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(h.attrs("send")...))
+	defer span.End()
+
+	sc := &spanContext{prop: h.prop, carrier: make(propagation.MapCarrier)}
+	h.prop.Inject(ctx, sc.carrier)
+	msg.Annotate(HeaderSpanContext, sc)
+
+	return true
+}
+
+// RecvHook records a consumer span as a child of the sender's span.
+func (h *Hook) RecvHook(msg *portal.Message) bool {
+	if sc, ok := msg.Header[HeaderSpanContext].(*spanContext); ok {
+		_, span := h.tracer.Start(sc.extract(), h.name+" recv",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(h.attrs("receive")...))
+		span.End()
+	}
+
+	return true
+}
+
+func (h *Hook) attrs(op string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "portal"),
+		attribute.String("messaging.operation", op),
+		attribute.String("portal.protocol", h.name),
+	}
+}
+
+// Middleware returns the hook as portal Middleware, so that it can be added to
+// any portal through its Cfg.
+func (h *Hook) Middleware() portal.Middleware {
+	return portal.Middleware{Send: h.SendHook, Recv: h.RecvHook}
+}
+
+type protocol struct {
+	portal.Protocol
+	hook *Hook
+}
+
+// Wrap adds tracing to a Protocol.  Any send or receive hooks implemented by
+// the protocol are preserved.
+//
+//	p := portal.MakePortal(cfg, tracing.Wrap(&push.Protocol{}))
+func Wrap(p portal.Protocol, opt ...Option) portal.Protocol {
+	h := NewHook(opt...)
+	h.name = p.Name()
+	return &protocol{Protocol: p, hook: h}
+}
+
+func (p *protocol) SendHook(msg *portal.Message) bool {
+	if !p.hook.SendHook(msg) {
+		return false
+	}
+
+	if h, ok := p.Protocol.(portal.ProtocolSendHook); ok {
+		return h.SendHook(msg)
+	}
+
+	return true
+}
+
+func (p *protocol) RecvHook(msg *portal.Message) bool {
+	if h, ok := p.Protocol.(portal.ProtocolRecvHook); ok && !h.RecvHook(msg) {
+		return false
+	}
+
+	return p.hook.RecvHook(msg)
+}
+
+// Send a value through the portal, along with the span context in ctx.  The
+// value itself is sent as-is.
+func Send(ctx context.Context, p portal.WriteOnly, v interface{}) {
+	p.SendHeader(v, portal.Header{headerContext: ctx})
+}
+
+// Recv a value from the portal, along with the sender's span context.  Spans
+// started from the returned context are part of the sender's trace.
+func Recv(p portal.ReadOnly) (context.Context, interface{}) {
+	v, h := p.RecvHeader()
+	return Extract(h), v
+}
+
+// Extract the sender's span context from the header of a message received
+// from a traced portal.  If the header does not carry a span context, a
+// background context is returned.
+func Extract(h portal.Header) context.Context {
+	if sc, ok := h[HeaderSpanContext].(*spanContext); ok {
+		return sc.extract()
+	}
+
+	return context.Background()
+}
+
+func (sc *spanContext) extract() context.Context {
+	return sc.prop.Extract(context.Background(), sc.carrier)
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/codec"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func mkStage(t *testing.T, addr string, tp trace.TracerProvider) (portal.Portal, portal.Portal) {
+	pushP := portal.MakePortal(portal.Cfg{}, Wrap(&push.Protocol{}, WithTracerProvider(tp)))
+	pullP := portal.MakePortal(portal.Cfg{}, Wrap(&pull.Protocol{}, WithTracerProvider(tp)))
+
+	if err := pushP.Bind(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pullP.Connect(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	return pushP, pullP
+}
+
+func TestPropagation(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+
+	in0, out0 := mkStage(t, "/test/tracing/stage0", tp)
+	defer in0.Close()
+	defer out0.Close()
+
+	in1, out1 := mkStage(t, "/test/tracing/stage1", tp)
+	defer in1.Close()
+	defer out1.Close()
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	go Send(ctx, in0, 42)
+
+	ctx0, v := Recv(out0)
+	if v.(int) != 42 {
+		t.Errorf("expected 42, got %v", v)
+	}
+
+	go Send(ctx0, in1, v)
+
+	ctx1, v := Recv(out1)
+	if v.(int) != 42 {
+		t.Errorf("expected 42, got %v", v)
+	}
+
+	root.End()
+
+	traceID := root.SpanContext().TraceID()
+	if id := trace.SpanContextFromContext(ctx1).TraceID(); id != traceID {
+		t.Errorf("trace was not propagated (expected trace %s, got %s)", traceID, id)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 5 { // root + 2 * (send + recv)
+		t.Errorf("expected 5 spans, got %d", len(spans))
+	}
+
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != traceID {
+			t.Errorf("span %s is not part of the root trace", s.Name)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	if ctx := Extract(nil); trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("unexpected span context in untraced header")
+	}
+}
+
+func TestUntracedRecv(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+
+	pushP := portal.MakePortal(portal.Cfg{Codec: codec.JSON{}}, Wrap(&push.Protocol{}, WithTracerProvider(tp)))
+	defer pushP.Close()
+	pullP := pull.New(portal.Cfg{Codec: codec.JSON{}}) // not traced
+	defer pullP.Close()
+
+	if err := pushP.Bind("/test/tracing/untraced"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pullP.Connect("/test/tracing/untraced"); err != nil {
+		t.Fatal(err)
+	}
+
+	go Send(context.Background(), pushP, "hello")
+
+	if v := pullP.Recv(); v != "hello" {
+		t.Errorf("expected hello, got %v (%T)", v, v)
+	}
+}
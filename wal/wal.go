@@ -0,0 +1,263 @@
+// Package wal provides a disk-backed portal.Log.
+//
+// Each log occupies a directory, which holds a single file of records.  An
+// append record holds a serialized value, and an ack record cancels an earlier
+// append.  Records are checksummed, so that a record torn by a crash is
+// discarded when the log is reopened.  The file is compacted when it is opened,
+// and truncated whenever every value has been acknowledged.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/lthibault/portal"
+	"github.com/pkg/errors"
+)
+
+var _ portal.Log = (*Log)(nil)
+
+const (
+	logName = "wal.log"
+
+	opAppend byte = 'A'
+	opAck    byte = 'K'
+
+	hdrSize = 1 + 8 + 4 + 4 // op, seq, len, crc
+
+	// MaxValueSize is the largest serialized value that can be appended
+	MaxValueSize = 64 << 20
+)
+
+// Log is a write-ahead log stored in a directory.  Appends are synced to disk
+// before they return; acks are not, so a value that was acknowledged shortly
+// before a crash may be replayed.  A Log is safe for concurrent use.
+type Log struct {
+	sync.Mutex
+	codec   portal.Codec
+	f       *os.File
+	seq     uint64
+	pending map[uint64][]byte
+}
+
+// Open the log in the specified directory, creating it if necessary.  Values are
+// serialized with the codec.
+func Open(dir string, c portal.Codec) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "mkdir")
+	}
+
+	l := &Log{codec: c, pending: make(map[uint64][]byte)}
+	if err := l.load(filepath.Join(dir, logName)); err != nil {
+		return nil, errors.Wrap(err, "load")
+	}
+
+	if err := l.compact(dir); err != nil {
+		return nil, errors.Wrap(err, "compact")
+	}
+
+	return l, nil
+}
+
+// load replays the records in the file, stopping at the first damaged record
+func (l *Log) load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	for remaining := fi.Size(); ; {
+		op, seq, b, err := readRecord(r, remaining)
+		if err != nil {
+			return nil // EOF, or a record torn by a crash
+		}
+		remaining -= int64(hdrSize + len(b))
+
+		switch op {
+		case opAppend:
+			l.pending[seq] = b
+		case opAck:
+			delete(l.pending, seq)
+		}
+
+		if seq > l.seq {
+			l.seq = seq
+		}
+	}
+}
+
+// compact rewrites the file so that it holds only the pending values
+func (l *Log) compact(dir string) (err error) {
+	tmp := filepath.Join(dir, logName+".tmp")
+
+	var f *os.File
+	if f, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+
+	w := bufio.NewWriter(f)
+	for _, seq := range l.seqs() {
+		if err = writeRecord(w, opAppend, seq, l.pending[seq]); err != nil {
+			f.Close()
+			return
+		}
+	}
+
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = os.Rename(tmp, filepath.Join(dir, logName)); err != nil {
+		return
+	}
+
+	l.f, err = os.OpenFile(filepath.Join(dir, logName), os.O_WRONLY|os.O_APPEND, 0644)
+	return
+}
+
+func (l *Log) seqs() []uint64 {
+	seqs := make([]uint64, 0, len(l.pending))
+	for seq := range l.pending {
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// Append implements portal.Log
+func (l *Log) Append(v interface{}) (uint64, error) {
+	b, err := l.codec.Encode(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "encode")
+	}
+
+	if len(b) > MaxValueSize {
+		return 0, errors.Errorf("value exceeds %d bytes", MaxValueSize)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.seq++
+	if err = writeRecord(l.f, opAppend, l.seq, b); err != nil {
+		return 0, errors.Wrap(err, "write")
+	}
+
+	if err = l.f.Sync(); err != nil {
+		return 0, errors.Wrap(err, "sync")
+	}
+
+	l.pending[l.seq] = b
+	return l.seq, nil
+}
+
+// Ack implements portal.Log
+func (l *Log) Ack(seq uint64) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.pending[seq]; !ok {
+		return errors.Errorf("no pending entry %d", seq)
+	}
+
+	delete(l.pending, seq)
+
+	if len(l.pending) == 0 {
+		return errors.Wrap(l.f.Truncate(0), "truncate")
+	}
+
+	return errors.Wrap(writeRecord(l.f, opAck, seq, nil), "write")
+}
+
+// Pending implements portal.Log
+func (l *Log) Pending() ([]portal.LogEntry, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	es := make([]portal.LogEntry, 0, len(l.pending))
+	for _, seq := range l.seqs() {
+		v, err := l.codec.Decode(l.pending[seq])
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode entry %d", seq)
+		}
+
+		es = append(es, portal.LogEntry{Seq: seq, Value: v})
+	}
+
+	return es, nil
+}
+
+// Len returns the number of pending values
+func (l *Log) Len() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return len(l.pending)
+}
+
+// Close the log
+func (l *Log) Close() error { return l.f.Close() }
+
+func writeRecord(w io.Writer, op byte, seq uint64, b []byte) error {
+	rec := make([]byte, hdrSize+len(b))
+	rec[0] = op
+	binary.BigEndian.PutUint64(rec[1:9], seq)
+	binary.BigEndian.PutUint32(rec[9:13], uint32(len(b)))
+	copy(rec[hdrSize:], b)
+	binary.BigEndian.PutUint32(rec[13:17], crc32.ChecksumIEEE(append(rec[:13:13], b...)))
+
+	_, err := w.Write(rec)
+	return err
+}
+
+// readRecord reads a record from r, which holds at most the specified number of
+// bytes.  A length that is out of bounds indicates a damaged record.
+func readRecord(r io.Reader, remaining int64) (op byte, seq uint64, b []byte, err error) {
+	hdr := make([]byte, hdrSize)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return
+	}
+
+	n := binary.BigEndian.Uint32(hdr[9:13])
+	if n > MaxValueSize || int64(n) > remaining-hdrSize {
+		err = errors.Errorf("record length %d out of bounds", n)
+		return
+	}
+
+	op = hdr[0]
+	seq = binary.BigEndian.Uint64(hdr[1:9])
+	b = make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return
+	}
+
+	if crc32.ChecksumIEEE(append(hdr[:13:13], b...)) != binary.BigEndian.Uint32(hdr[13:17]) {
+		err = errors.New("checksum mismatch")
+	}
+
+	return
+}
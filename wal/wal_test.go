@@ -0,0 +1,242 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lthibault/portal"
+	"github.com/lthibault/portal/codec"
+	"github.com/lthibault/portal/proto/pull"
+	"github.com/lthibault/portal/proto/push"
+)
+
+func mustOpen(t *testing.T, dir string) *Log {
+	l, err := Open(dir, codec.JSON{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func waitEmpty(t *testing.T, l *Log) {
+	deadline := time.Now().Add(time.Second)
+	for l.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected empty log, got %d pending", l.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+
+	l := mustOpen(t, dir)
+	for _, v := range []string{"a", "b", "c"} {
+		if _, err := l.Append(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Ack(2); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// simulate a record torn by a crash
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{opAppend, 0, 0, 0})
+	f.Close()
+
+	l = mustOpen(t, dir)
+	defer l.Close()
+
+	es, err := l.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(es) != 2 {
+		t.Fatalf("expected 2 pending entries, got %d", len(es))
+	}
+
+	if es[0].Seq != 1 || es[0].Value != "a" || es[1].Seq != 3 || es[1].Value != "c" {
+		t.Errorf("unexpected entries %v", es)
+	}
+
+	if seq, err := l.Append("d"); err != nil {
+		t.Fatal(err)
+	} else if seq != 4 {
+		t.Errorf("expected seq=4, got %d", seq)
+	}
+}
+
+func TestCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+
+	l := mustOpen(t, dir)
+	if _, err := l.Append("a"); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// a damaged header must not cause a huge allocation
+	hdr := make([]byte, hdrSize)
+	hdr[0] = opAppend
+	hdr[9], hdr[10], hdr[11], hdr[12] = 0xff, 0xff, 0xff, 0xff
+
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(hdr)
+	f.Close()
+
+	l = mustOpen(t, dir)
+	defer l.Close()
+
+	if n := l.Len(); n != 1 {
+		t.Errorf("expected 1 pending entry, got %d", n)
+	}
+
+	if _, err := l.Append(strings.Repeat("x", MaxValueSize)); err == nil {
+		t.Error("expected oversized value to be rejected")
+	}
+}
+
+func TestSendLog(t *testing.T) {
+	dir := t.TempDir()
+
+	l := mustOpen(t, dir)
+	p := push.New(portal.Cfg{Size: 1, SendLog: l})
+	if err := p.Bind("/test/wal/send/0"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Send("hello") // no peers; the value is never delivered
+	p.Close()
+	l.Close()
+
+	l = mustOpen(t, dir)
+	defer l.Close()
+
+	if n := l.Len(); n != 1 {
+		t.Fatalf("expected 1 pending value, got %d", n)
+	}
+
+	p = push.New(portal.Cfg{SendLog: l})
+	defer p.Close()
+
+	pl := pull.New(portal.Cfg{})
+	defer pl.Close()
+
+	if err := p.Bind("/test/wal/send/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pl.Connect("/test/wal/send/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := pl.Recv(); v != "hello" {
+		t.Errorf("expected replayed value, got %v", v)
+	}
+
+	waitEmpty(t, l)
+}
+
+func TestRecvLog(t *testing.T) {
+	dir := t.TempDir()
+
+	p := push.New(portal.Cfg{})
+	defer p.Close()
+
+	if err := p.Bind("/test/wal/recv"); err != nil {
+		t.Fatal(err)
+	}
+
+	l := mustOpen(t, dir)
+	pl := pull.New(portal.Cfg{RecvLog: l})
+	if err := pl.Connect("/test/wal/recv"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Send("hello") // returns once the value is persisted
+
+	if n := l.Len(); n != 1 {
+		t.Fatalf("expected 1 pending value, got %d", n)
+	}
+
+	pl.Close()
+	l.Close()
+
+	l = mustOpen(t, dir)
+	defer l.Close()
+
+	pl = pull.New(portal.Cfg{RecvLog: l})
+	defer pl.Close()
+
+	if err := pl.Connect("/test/wal/recv"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := pl.Recv(); v != "hello" {
+		t.Errorf("expected replayed value, got %v", v)
+	}
+
+	waitEmpty(t, l)
+}
+
+func TestRequeue(t *testing.T) {
+	dir := t.TempDir()
+
+	p := push.New(portal.Cfg{})
+	defer p.Close()
+
+	if err := p.Bind("/test/wal/requeue"); err != nil {
+		t.Fatal(err)
+	}
+
+	l := mustOpen(t, dir)
+	a := pull.NewAck(portal.Cfg{RecvLog: l})
+	if err := a.Connect("/test/wal/requeue"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Send("hello")
+	d := a.Recv()
+
+	b := pull.NewAck(portal.Cfg{})
+	defer b.Close()
+
+	if err := b.Connect("/test/wal/requeue"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Nack(true); err != nil { // handed over to b
+		t.Fatal(err)
+	}
+
+	if d := b.Recv(); d.Value != "hello" {
+		t.Errorf("expected requeued value, got %v", d.Value)
+	} else {
+		d.Ack()
+	}
+
+	waitEmpty(t, l)
+	a.Close()
+	l.Close()
+
+	l = mustOpen(t, dir)
+	defer l.Close()
+
+	if n := l.Len(); n != 0 {
+		t.Errorf("expected requeued value to leave the log, got %d pending", n)
+	}
+}